@@ -0,0 +1,161 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"go/build/constraint"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// arch is a target architecture together with the
+// backends that are able to assemble for it
+type arch struct {
+	name     string
+	backends []backend // in order of preference
+	format   func(opcodes []byte, instr string, commentPos int, inDefine bool) (string, error)
+	requires func(instr string) []string // capabilities needed to encode an instruction
+	probes   map[string]string           // instruction to probe backends for every capability
+}
+
+var archAmd64 = &arch{
+	name: "amd64",
+	backends: []backend{
+		{name: "yasm", as: yasm, version: yasmVersion},
+		{name: "gas", as: gasAmd64, version: gasVersionAmd64},
+	},
+	format:   toPlan9s,
+	requires: requiresAmd64,
+	probes: map[string]string{
+		"avx512": "VPADDQ ZMM0 {k1}{z}, ZMM1, ZMM2",
+	},
+}
+
+var archArm64 = &arch{
+	name: "arm64",
+	backends: []backend{
+		{name: "gas", as: gasArm64, version: gasVersionArm64},
+	},
+	format:   toPlan9sArm64,
+	requires: requiresArm64,
+}
+
+// archs lists all supported target architectures
+var archs = []*arch{archAmd64, archArm64}
+
+// lookupArch returns the architecture with the given name, or nil
+func lookupArch(name string) *arch {
+	for _, a := range archs {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func archNames() []string {
+	names := make([]string, len(archs))
+	for i, a := range archs {
+		names[i] = a.name
+	}
+	return names
+}
+
+// detectArch determines the target architecture for the lines of a file.
+// An explicitly given architecture takes precedence, followed by the
+// suffix of the file name and a //go:build (or +build) line in the header
+// of the file. If none of these decide, the host architecture is used.
+func detectArch(explicit, filename string, lines []string) (*arch, error) {
+	if explicit != "" {
+		if a := lookupArch(explicit); a != nil {
+			return a, nil
+		}
+		return nil, fmt.Errorf("unsupported architecture %q (use one of %s)", explicit, strings.Join(archNames(), ", "))
+	}
+
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	for _, a := range archs {
+		if strings.HasSuffix(base, "_"+a.name) {
+			return a, nil
+		}
+	}
+
+	if a := archFromBuildConstraint(lines); a != nil {
+		return a, nil
+	}
+
+	if a := lookupArch(runtime.GOARCH); a != nil {
+		return a, nil
+	}
+	return nil, fmt.Errorf("cannot determine target architecture, use -arch to select one of %s", strings.Join(archNames(), ", "))
+}
+
+// Known values of GOOS and GOARCH used when evaluating build constraints
+var (
+	knownOS   = "aix android darwin dragonfly freebsd hurd illumos ios js linux nacl netbsd openbsd plan9 solaris wasip1 windows zos unix"
+	knownArch = "386 amd64 arm arm64 loong64 mips mipsle mips64 mips64le ppc64 ppc64le riscv64 s390x wasm"
+)
+
+// archFromBuildConstraint returns the single supported architecture that
+// satisfies the build constraints in the header of the file, or nil.
+// Operating systems and the gc compiler are assumed to match while any
+// other build tag is assumed to be unset.
+func archFromBuildConstraint(lines []string) *arch {
+	var match *arch
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "//") {
+			break // end of header
+		}
+		if !constraint.IsGoBuild(trimmed) && !constraint.IsPlusBuild(trimmed) {
+			continue
+		}
+		expr, err := constraint.Parse(trimmed)
+		if err != nil {
+			continue
+		}
+		match = nil
+		for _, a := range archs {
+			ok := expr.Eval(func(tag string) bool {
+				switch {
+				case tag == a.name:
+					return true
+				case strings.Contains(" "+knownArch+" ", " "+tag+" "):
+					return false
+				case tag == "gc" || strings.HasPrefix(tag, "go1."):
+					return true
+				}
+				return strings.Contains(" "+knownOS+" ", " "+tag+" ")
+			})
+			if ok {
+				if match != nil {
+					return nil // ambiguous
+				}
+				match = a
+			}
+		}
+		if constraint.IsGoBuild(trimmed) {
+			break // //go:build takes precedence over // +build
+		}
+	}
+	return match
+}
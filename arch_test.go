@@ -0,0 +1,150 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDetectArch(t *testing.T) {
+
+	host := runtime.GOARCH
+	if lookupArch(host) == nil {
+		host = ""
+	}
+
+	testCases := []struct {
+		explicit string
+		filename string
+		lines    []string
+		out      string
+	}{
+		{"", "highwayhash_arm64.s", nil, "arm64"},
+		{"", "dir/compressAvx_amd64.s", nil, "amd64"},
+		{"", "sha256block_linux_arm64.s", nil, "arm64"},
+		{"arm64", "compressAvx_amd64.s", nil, "arm64"},
+		{"", "sha256block.s", []string{"// Copyright", "", "//go:build arm64 && !noasm && gc", "", "#include \"textflag.h\""}, "arm64"},
+		{"", "sha256block.s", []string{"//go:build (linux || darwin) && amd64", "// +build linux darwin", "// +build amd64"}, "amd64"},
+		{"", "sha256block.s", []string{"// +build arm64,!noasm"}, "arm64"},
+		{"", "sha256block.s", []string{"//go:build !arm64"}, host},
+		{"", "sha256block.s", []string{"TEXT ·foo(SB), 7, $0", "//go:build arm64"}, host},
+		{"", "", nil, host},
+	}
+
+	for i, tc := range testCases {
+		a, err := detectArch(tc.explicit, tc.filename, tc.lines)
+		name := ""
+		if err == nil {
+			name = a.name
+		}
+		if name != tc.out {
+			t.Errorf("test %d: expected %q\ngot                     %q (%v)", i, tc.out, name, err)
+		}
+	}
+
+	if _, err := detectArch("ppc64", "", nil); err == nil {
+		t.Errorf("expected error for unsupported architecture")
+	}
+}
+
+func TestToPlan9sArm64(t *testing.T) {
+
+	opcodes := []byte{0x70, 0x28, 0xdf, 0x4c, 0x00, 0xd8, 0xa1, 0x4e}
+	out := "    WORD $0x4cdf2870; WORD $0x4ea1d800 // ld1    {v16.4s-v19.4s}, [x3], #64"
+
+	result, err := toPlan9sArm64(opcodes, " ld1    {v16.4s-v19.4s}, [x3], #64", 0, false)
+	if err != nil || result != out {
+		t.Errorf("expected %s\ngot                     %s (%v)", out, result, err)
+	}
+
+	if _, err := toPlan9sArm64(opcodes[:3], "", 0, false); err == nil {
+		t.Errorf("expected error for partial opcode")
+	}
+}
+
+func TestRequiresArm64(t *testing.T) {
+
+	testCases := []struct {
+		instr string
+		out   string
+	}{
+		{" ld1    {v16.4s-v19.4s}, [x3], #64", ""},
+		{" aese v0.16b, v1.16b", ""},
+		{" eor3 v0.16b, v1.16b, v2.16b, v3.16b", "sha3"},
+		{" SHA512H q0, q1, v2.2d", "sha3"},
+		{" sm4e v0.4s, v1.4s", "sm4"},
+		{" udot v0.4s, v1.16b, v2.16b", "dotprod"},
+		{" add z0.s, z1.s, z2.s", "sve"},
+		{" ld1w {z0.s}, p0/z, [x0]", "sve"},
+		{" eor3 z0.d, z0.d, z1.d, z2.d", "sve2"},
+		{" aese z0.b, z0.b, z1.b", "sve2-aes"},
+		{" AESD z0.b, z0.b, z1.b", "sve2-aes"},
+		{" aesmc z0.b, z0.b", "sve2-aes"},
+		{" aesimc z0.b, z0.b", "sve2-aes"},
+		{" pmullb z0.q, z1.d, z2.d", "sve2-aes"},
+		{" pmullt z0.Q, z1.d, z2.d", "sve2-aes"},
+		{" pmullb z0.h, z1.b, z2.b", "sve2"},
+		{" sm4e z0.s, z0.s, z1.s", "sve2-sm4"},
+		{" sm4ekey z0.s, z1.s, z2.s", "sve2-sm4"},
+		{" rax1 z0.d, z1.d, z2.d", "sve2-sha3"},
+		{" rax1 v0.2d, v1.2d, v2.2d", "sha3"},
+		{" bdep z0.s, z1.s, z2.s", "sve2-bitperm"},
+		{" add x0, x1, x2", ""},
+	}
+
+	for i, tc := range testCases {
+		result := strings.Join(requiresArm64(tc.instr), ",")
+		if result != tc.out {
+			t.Errorf("test %d: expected %q\ngot                     %q for %s", i, tc.out, result, tc.instr)
+		}
+	}
+}
+
+func TestExtensionGroups(t *testing.T) {
+
+	instructions := []Instruction{
+		{instruction: " histcnt z0.s, p0/z, z1.s, z2.s"},
+		{instruction: " sqdmlalb z0.s, z1.h, z2.h", extensions: []string{"sve2"}},
+		{instruction: " add x0, x1, x2"},
+		{instruction: " sqdmlalb z0.s, z1.h, z2.h"},
+		{instruction: " eor3 v0.16b, v1.16b, v2.16b, v3.16b"},
+		{instruction: " sub x0, x1, x2"},
+	}
+
+	order, groups := extensionGroups(instructions)
+	want := []struct {
+		directives string
+		lines      []int
+	}{
+		{".arch_extension sve2\n", []int{0}},
+		{".arch_extension sve\n.arch_extension sve2\n", []int{1}},
+		{"", []int{2, 5}},
+		{".arch_extension sve\n", []int{3}},
+		{".arch_extension sha3\n", []int{4}},
+	}
+	if len(order) != len(want) {
+		t.Fatalf("expected %d groups\ngot                     %d: %q", len(want), len(order), order)
+	}
+	for i, w := range want {
+		if order[i] != w.directives || fmt.Sprint(groups[order[i]]) != fmt.Sprint(w.lines) {
+			t.Errorf("expected %q for %v\ngot                     %q for %v", w.directives, w.lines, order[i], groups[order[i]])
+		}
+	}
+}
@@ -20,7 +20,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"os"
 	"strings"
 )
@@ -29,15 +29,69 @@ type Instruction struct {
 	instruction string
 	lineno      int
 	commentPos  int
+	marker      string // explicit marker in front of the instruction, if any
+	comment     string // text following the //, written back as is
+	col         int    // column of the instruction text in the original line (1-based)
 	inDefine    bool
+	inRegion    bool
+	failed      bool     // rejected by the assembler
+	extensions  []string // ISA extensions enabled explicitly
+	backend     string
 	assembled   string
 	opcodes     []byte
 }
 
+// CompactOptions controls how instructions on consecutive lines
+// are combined into a single sequence of opcodes
+type CompactOptions struct {
+	Enabled    bool
+	MaxBytes   int  // maximum number of opcodes per line (0 for no limit)
+	CrossBlank bool // whether runs continue across blank and comment lines
+	Regions    bool // only compact within //asm2plan9s:compact regions
+}
+
+// Directives to mark regions to be compacted
+const (
+	directiveCompact    = "//asm2plan9s:compact"
+	directiveEndCompact = "//asm2plan9s:endcompact"
+)
+
+// Suggested tag for marking an instruction regardless of its position
+// (with -marker), eg. "//asm: VPADDQ XMM0, XMM1, XMM8"
+const exampleMarker = "asm:"
+
+// Directives setting the architecture level for the file (arm64 only, in
+// the header of the file), eg. "//asm2plan9s:march armv8.2-a+sha3", and enabling ISA extensions for
+// the next instruction, eg. "//asm2plan9s:arch_extension sve2"
+const (
+	directiveMarch         = "//asm2plan9s:march "
+	directiveArchExtension = "//asm2plan9s:arch_extension "
+)
+
+// Options configures a single run of the assembler
+type Options struct {
+	Filename string // name of the file being assembled, used in messages
+	Arch     string // target architecture, detected from the file when empty
+	Backend  string // name of the backend to use, or "auto"
+	As       string // GNU assemblers or cross prefixes to use, see gasCommand
+	Jobs     int    // workers assembling single instructions, see setMaxProcs
+	March    string // architecture level and extensions for arm64, eg. armv8.2-a+sha3
+	CacheDir string // directory to cache encodings in, empty to disable caching
+	Lockfile string // lockfile to record encodings in, or to verify against
+	Verify   bool   // take encodings from the lockfile instead of an assembler
+	Marker   string // tag marking instructions explicitly, empty to disable
+	Compact  CompactOptions
+	Verbose  bool      // report the backend used for every file
+	Explain  bool      // report why every comment is (not) an instruction
+	Log      io.Writer // destination for diagnostics, may be nil
+}
+
 type Assembler struct {
 	Prescan      bool
 	Instructions []Instruction
-	Compact      bool
+	Compact      CompactOptions
+	Marker       string // tag marking instructions explicitly, see exampleMarker
+	arch         *arch
 }
 
 // assemble assembles an array of lines into their
@@ -45,20 +99,27 @@ type Assembler struct {
 func (a *Assembler) assemble(lines []string) ([]string, error) {
 
 	result := make([]string, 0)
+	inRegion := false
+	var extensions []string
 
 	for lineno, line := range lines {
-		startsWithTab := strings.HasPrefix(line, "\t")
-		line := strings.Replace(line, "\t", "    ", -1)
-		fields := strings.Split(line, "//")
-		if len(fields) == 2 && (startsAfterLongWordByteSequence(fields[0]) || len(fields[0]) == 65) {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == directiveCompact:
+			inRegion = true
+		case trimmed == directiveEndCompact:
+			inRegion = false
+		case strings.HasPrefix(trimmed, directiveArchExtension):
+			extensions = append(extensions, strings.Fields(trimmed[len(directiveArchExtension):])...)
+		}
 
-			// test whether string before instruction is terminated with a backslash (so used in a #define)
-			trimmed := strings.TrimSpace(fields[0])
-			inDefine := len(trimmed) > 0 && string(trimmed[len(trimmed)-1]) == `\`
+		if d := detect(line, a.Marker); d.instruction {
 
 			// While prescanning collect the instructions
 			if a.Prescan {
-				ins := Instruction{instruction: fields[1], lineno: lineno, commentPos: len(fields[0]), inDefine: inDefine}
+				ins := Instruction{instruction: d.text, marker: d.marker, comment: d.comment, lineno: lineno, commentPos: width(d.prefix), inDefine: d.inDefine, inRegion: inRegion, extensions: extensions}
+				ins.col = column(lines[lineno], ins.marker)
+				extensions = nil
 				a.Instructions = append(a.Instructions, ins)
 				continue
 			}
@@ -70,19 +131,13 @@ func (a *Assembler) assemble(lines []string) ([]string, error) {
 				}
 			}
 			if ins == nil {
-				if a.Compact {
+				if a.Compact.Enabled {
 					continue
 				}
-				panic("failed to find entry with correct line number")
-			}
-			if startsWithTab {
-				ins.assembled = strings.Replace(ins.assembled, "    ", "\t", 1)
+				return nil, &AssembleError{Line: lineno + 1, Col: column(lines[lineno], ""), Instruction: strings.TrimSpace(d.text), Msg: "failed to find entry with correct line number"}
 			}
 			result = append(result, ins.assembled)
 		} else if !a.Prescan {
-			if startsWithTab {
-				line = strings.Replace(line, "    ", "\t", 1)
-			}
 			result = append(result, line)
 		}
 	}
@@ -90,92 +145,202 @@ func (a *Assembler) assemble(lines []string) ([]string, error) {
 	return result, nil
 }
 
-// startsAfterLongWordByteSequence determines if an assembly instruction
-// starts on a position after a combination of LONG, WORD, BYTE sequences
-func startsAfterLongWordByteSequence(prefix string) bool {
+// marchFromHeader returns the architecture level set by the first march
+// directive in the header of the file (the comments in front of the code)
+func marchFromHeader(lines []string) string {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "//") {
+			break // end of header
+		}
+		if strings.HasPrefix(trimmed, directiveMarch) {
+			return strings.TrimSpace(trimmed[len(directiveMarch):])
+		}
+	}
+	return ""
+}
 
-	if len(strings.TrimSpace(prefix)) != 0 && !strings.HasPrefix(prefix, "    LONG $0x") &&
-		!strings.HasPrefix(prefix, "    WORD $0x") && !strings.HasPrefix(prefix, "    BYTE $0x") {
-		return false
+// column returns the column (1-based, counting runes) at which the
+// instruction in the comment of line starts, following the marker if any
+func column(line, marker string) int {
+	pos := strings.Index(line, "//") + 2
+	if strings.HasPrefix(line[pos:], marker) {
+		pos += len(marker)
 	}
+	return width(line) - width(strings.TrimLeft(line[pos:], " \t")) + 1
+}
 
-	length := 4 + len(prefix) + 1
+// reportRequirements logs the capabilities or extensions needed for every instruction
+func (a *Assembler) reportRequirements(opts *Options) {
+	if a.arch.requires == nil {
+		return
+	}
+	for _, ins := range a.Instructions {
+		reqs := append(a.arch.requires(ins.instruction), ins.extensions...)
+		if len(reqs) > 0 {
+			fmt.Fprintf(opts.Log, "%s:%d: '%s' needs %s\n", opts.Filename, ins.lineno+1, strings.TrimSpace(ins.instruction), strings.Join(reqs, ", "))
+		}
+	}
+}
 
-	for objcodes := 3; objcodes <= 8; objcodes++ {
+// reportBackends logs which backend encoded which lines
+func (a *Assembler) reportBackends(opts *Options) {
+	lines := make(map[string][]int)
+	names := make([]string, 0)
+	for _, ins := range a.Instructions {
+		if ins.backend == "" {
+			continue // failed to assemble
+		}
+		if _, ok := lines[ins.backend]; !ok {
+			names = append(names, ins.backend)
+		}
+		lines[ins.backend] = append(lines[ins.backend], ins.lineno+1)
+	}
+	for _, name := range names {
+		fmt.Fprintf(opts.Log, "%s: assembled for %s with %s: lines %s\n", opts.Filename, a.arch.name, name, lineRanges(lines[name]))
+	}
+}
 
-		ls, ws, bs := 0, 0, 0
+// combineLines shortens the output by combining consecutive lines into a larger list of opcodes
+func (a *Assembler) combineLines(lines []string) {
+	startLine, lastLine, opcodes := -1, -1, make([]byte, 0, 1024)
+	combined := make([]Instruction, 0, 100)
 
-		oc := objcodes
+	flush := func() {
+		if startLine != -1 {
+			combiAssem, _ := a.arch.format(opcodes, "", 0, false)
+			if strings.HasSuffix(lines[startLine], "\r") {
+				combiAssem += "\r"
+			}
+			combined = append(combined, Instruction{assembled: combiAssem, lineno: startLine, inDefine: false})
+		}
+		startLine, opcodes = -1, opcodes[:0]
+	}
 
-		for ; oc >= 4; oc -= 4 {
-			ls++
+	for _, ins := range a.Instructions {
+		// Instructions in a #define, outside of a marked region or that
+		// failed to assemble are left alone
+		if ins.inDefine || (a.Compact.Regions && !ins.inRegion) || ins.failed {
+			flush()
+			combined = append(combined, ins)
+			continue
 		}
-		if oc >= 2 {
-			ws++
-			oc -= 2
+		if startLine != -1 {
+			if !a.Compact.adjacent(lines, lastLine, ins.lineno) || // we have found a non-consecutive line
+				(a.Compact.MaxBytes > 0 && len(opcodes)+len(ins.opcodes) > a.Compact.MaxBytes) {
+				flush()
+			}
 		}
-		if oc == 1 {
-			bs++
+		if startLine == -1 {
+			startLine = ins.lineno
 		}
-		size := 4 + ls*(len("LONG $0x")+8) + ws*(len("WORD $0x")+4) + bs*(len("BYTE $0x")+2) + (ls+ws+bs-1)*len("; ")
+		opcodes = append(opcodes, ins.opcodes...)
+		lastLine = ins.lineno
+	}
+	flush()
+
+	a.Instructions = combined
+}
 
-		if length == size+6 { // comment starts after a space
-			return true
+// adjacent determines whether the instructions on lines prev and next may
+// be part of the same run
+func (c CompactOptions) adjacent(lines []string, prev, next int) bool {
+	if next == prev+1 {
+		return true
+	}
+	if !c.CrossBlank {
+		return false
+	}
+	for _, line := range lines[prev+1 : next] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-// combineLines shortens the output by combining consecutive lines into a larger list of opcodes
-func (a *Assembler) combineLines() {
-	startIndex, startLine, opcodes := -1, -1, make([]byte, 0, 1024)
-	combined := make([]Instruction, 0, 100)
-	for i, ins := range a.Instructions {
-		if startIndex == -1 {
-			startIndex, startLine = i, ins.lineno
-		}
-		if ins.lineno != startLine+(i-startIndex) { // we have found a non-consecutive line
-			combiAssem, _ := toPlan9s(opcodes, "", 0, false)
-			combiIns := Instruction{assembled: combiAssem, lineno: startLine, inDefine: false}
+type staleLine struct {
+	lineno int
+	have   string
+	want   string
+}
 
-			combined = append(combined, combiIns)
-			opcodes = opcodes[:0]
-			startIndex, startLine = i, ins.lineno
+// staleLines compares the original lines with the assembled result and
+// returns the lines whose byte sequence is out of date.
+func staleLines(lines, result []string) []staleLine {
+	stale := make([]staleLine, 0)
+	for lineno := 0; lineno < len(lines) && lineno < len(result); lineno++ {
+		have, want := byteSequence(lines[lineno]), byteSequence(result[lineno])
+		if have == want {
+			continue // differences in white space or comments do not matter
 		}
-		opcodes = append(opcodes, ins.opcodes...)
+		stale = append(stale, staleLine{lineno: lineno, have: have, want: want})
 	}
-	if len(opcodes) > 0 {
-		combiAssem, _ := toPlan9s(opcodes, "", 0, false)
-		ins := Instruction{assembled: combiAssem, lineno: startLine, inDefine: false}
-
-		combined = append(combined, ins)
+	if len(lines) != len(result) {
+		stale = append(stale, staleLine{lineno: len(lines) - 1, have: fmt.Sprintf("%d lines", len(lines)), want: fmt.Sprintf("%d lines", len(result))})
 	}
+	return stale
+}
 
-	a.Instructions = combined
+// byteSequence returns the QUAD/LONG/WORD/BYTE sequence in front of the instruction
+func byteSequence(line string) string {
+	seq := strings.SplitN(line, "//", 2)[0]
+	seq = strings.TrimSpace(seq)
+	seq = strings.TrimSuffix(seq, `\`)
+	return strings.TrimSpace(seq)
+}
+
+// rewritePrefix returns the assembled line for line, which only differs from
+// line when the byte sequence changed. An existing sequence is replaced in
+// place, keeping the white space around it; otherwise the assembled line is
+// used, retaining a tab indenting line.
+func rewritePrefix(line, assembled string) string {
+	have, want := byteSequence(line), byteSequence(assembled)
+	if have == want {
+		return line
+	}
+	if want != "" && regexpSequenceValues.MatchString(have) {
+		start := strings.Index(line, have)
+		return line[:start] + want + line[start+len(have):]
+	}
+	if strings.HasPrefix(line, "\t") && strings.HasPrefix(assembled, "    ") {
+		return "\t" + assembled[4:]
+	}
+	return assembled
 }
 
-// readLines reads a whole file into memory
-// and returns a slice of its lines.
-func readLines(path string, in io.Reader) ([]string, error) {
+// readLines reads a whole file into memory and returns a slice of its
+// lines, and whether the last line ends in a newline. Lines ending in
+// CRLF keep the carriage return, so that writeLines restores the file
+// exactly.
+func readLines(path string, in io.Reader) (lines []string, newline bool, err error) {
 	if in == nil {
 		file, err := os.Open(path)
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		defer file.Close()
 		in = file
 	}
 
-	var lines []string
-	scanner := bufio.NewScanner(in)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	b, err := ioutil.ReadAll(in)
+	if err != nil || len(b) == 0 {
+		return nil, false, err
 	}
-	return lines, scanner.Err()
+	lines = strings.Split(string(b), "\n")
+	if newline = lines[len(lines)-1] == ""; newline {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, newline, nil
 }
 
-// writeLines writes the lines to the given file.
-func writeLines(lines []string, path string, out io.Writer) error {
+// writeLines writes the lines to the given file, terminating the
+// last line with a newline if requested, see readLines.
+func writeLines(lines []string, newline bool, path string, out io.Writer) error {
 	if path != "" {
 		file, err := os.Create(path)
 		if err != nil {
@@ -186,67 +351,114 @@ func writeLines(lines []string, path string, out io.Writer) error {
 	}
 
 	w := bufio.NewWriter(out)
-	for _, line := range lines {
-		fmt.Fprintln(w, line)
+	for i, line := range lines {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+		w.WriteString(line)
+	}
+	if newline && len(lines) > 0 {
+		w.WriteString("\n")
 	}
 	return w.Flush()
 }
 
 func assemble(lines []string, compact bool) (result []string, err error) {
+	return assembleWith(lines, Options{Compact: CompactOptions{Enabled: compact}})
+}
 
-	// TODO: Make compaction configurable
-	a := Assembler{Prescan: true, Compact: compact}
+// assembleWith assembles the lines with the given options. When some of the
+// instructions are rejected by the assembler, the result holds the lines
+// with these instructions left unchanged and the errors are returned as
+// AssembleErrors.
+func assembleWith(lines []string, opts Options) (result []string, err error) {
 
-	_, err = a.assemble(lines)
+	arch, err := detectArch(opts.Arch, opts.Filename, lines)
 	if err != nil {
 		return result, err
 	}
 
-	err = as(a.Instructions)
-	if err != nil {
-		return result, err
+	if opts.Log != nil && (opts.Explain || opts.Verbose) {
+		explain(opts.Log, opts.Filename, lines, opts.Marker, opts.Explain)
 	}
 
-	if a.Compact {
-		a.combineLines()
-	}
+	a := Assembler{Prescan: true, Compact: opts.Compact, Marker: opts.Marker, arch: arch}
 
-	a.Prescan = false
-	result, err = a.assemble(lines)
+	_, err = a.assemble(lines)
 	if err != nil {
 		return result, err
 	}
-
-	return result, nil
-}
-
-func main() {
-
-	file := ""
-	if len(os.Args) >= 2 {
-		file = os.Args[1]
+	if march := marchFromHeader(lines); march != "" {
+		opts.March = march
+	}
+	if opts.Log != nil && opts.Verbose {
+		a.reportRequirements(&opts)
 	}
 
-	var lines []string
-	var err error
-	if len(file) > 0 {
-		fmt.Println("Processing file", file)
-		lines, err = readLines(file, nil)
-	} else {
-		lines, err = readLines("", os.Stdin)
+	var rejected AssembleErrors
+	if len(a.Instructions) > 0 {
+		if opts.Verify {
+			if err := fromLockfile(opts.Lockfile, a.Instructions, arch); err != nil {
+				return result, err
+			}
+		} else {
+			failed, err := as(a.Instructions, arch, &opts)
+			if errs, ok := err.(AssembleErrors); ok {
+				rejected = errs
+			} else if err != nil {
+				return result, err
+			}
+			// Always name the backends used after falling back, the
+			// reasons for the fallback are only reported when asked for
+			if opts.Log != nil && opts.Verbose {
+				for _, f := range failed {
+					if e, ok := f.err.(*AssembleError); ok {
+						fmt.Fprintf(opts.Log, "%v (fell back)\n", e)
+						continue
+					}
+					fmt.Fprintf(opts.Log, "%s: %s backend failed: %v\n", opts.Filename, f.backend, f.err)
+				}
+			}
+			if opts.Log != nil && (opts.Verbose || len(failed) > 0) {
+				a.reportBackends(&opts)
+			}
+			if opts.Lockfile != "" && len(rejected) == 0 {
+				if err := writeLockfile(opts.Lockfile, a.Instructions, arch, &opts); err != nil {
+					return result, err
+				}
+			}
+		}
+		for i := range a.Instructions {
+			ins := &a.Instructions[i]
+			if ins.backend == "" && len(rejected) > 0 {
+				// Leave instructions that failed to assemble unchanged
+				ins.failed = true
+				ins.assembled = lines[ins.lineno]
+				continue
+			}
+			ins.assembled, err = arch.format(ins.opcodes, ins.comment, ins.commentPos, ins.inDefine)
+			if err != nil {
+				return result, err
+			}
+			ins.assembled = rewritePrefix(lines[ins.lineno], ins.assembled)
+		}
 	}
-	if err != nil {
-		log.Fatalf("readLines: %s", err)
+
+	if a.Compact.Enabled {
+		a.combineLines(lines)
 	}
 
-	result, err := assemble(lines, false)
-	if err != nil {
-		fmt.Print(err)
-		os.Exit(-1)
+	a.Prescan = false
+	result, err = a.assemble(lines)
+	if e, ok := err.(*AssembleError); ok {
+		e.File = opts.Filename
+		return result, e
+	} else if err != nil {
+		return result, err
 	}
 
-	err = writeLines(result, file, os.Stdout)
-	if err != nil {
-		log.Fatalf("writeLines: %s", err)
+	if len(rejected) > 0 {
+		return result, rejected
 	}
+	return result, nil
 }
@@ -0,0 +1,282 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Default architecture level passed to as, see https://gcc.gnu.org/onlinedocs/gcc-4.9.1/gcc/ARM-Options.html
+const defaultMarch = "armv8-a+crypto"
+
+// marchFlag returns the -march flag for the architecture level in opts
+func marchFlag(opts *Options) string {
+	if opts.March == "" {
+		return "-march=" + defaultMarch
+	}
+	return "-march=" + opts.March
+}
+
+func gasVersionArm64(opts *Options) (string, error) {
+	return gasIdentity("arm64", opts, marchFlag(opts))
+}
+
+// Instructions that need an ISA extension on top of the base architecture
+var arm64Extensions = map[string]string{
+	"eor3": "sha3", "bcax": "sha3", "rax1": "sha3", "xar": "sha3",
+	"sha512h": "sha3", "sha512h2": "sha3", "sha512su0": "sha3", "sha512su1": "sha3",
+	"sm3ss1": "sm4", "sm3tt1a": "sm4", "sm3tt1b": "sm4", "sm3tt2a": "sm4", "sm3tt2b": "sm4",
+	"sm3partw1": "sm4", "sm3partw2": "sm4", "sm4e": "sm4", "sm4ekey": "sm4",
+	"sdot": "dotprod", "udot": "dotprod",
+	"smmla": "i8mm", "ummla": "i8mm", "usmmla": "i8mm", "usdot": "i8mm", "sudot": "i8mm",
+	"bfdot": "bf16", "bfmmla": "bf16", "bfmlalb": "bf16", "bfmlalt": "bf16", "bfcvt": "bf16", "bfcvtn": "bf16", "bfcvtn2": "bf16",
+	"sqrdmlah": "rdma", "sqrdmlsh": "rdma",
+	"crc32b": "crc", "crc32h": "crc", "crc32w": "crc", "crc32x": "crc",
+	"crc32cb": "crc", "crc32ch": "crc", "crc32cw": "crc", "crc32cx": "crc",
+	"ldapr": "rcpc", "ldaprb": "rcpc", "ldaprh": "rcpc",
+}
+
+// SVE2 only instructions (when operating on SVE registers), with the
+// extension that enables them
+var sve2Instructions = map[string]string{
+	"eor3": "sve2", "bcax": "sve2", "xar": "sve2", "bsl": "sve2", "bsl1n": "sve2", "bsl2n": "sve2", "nbsl": "sve2",
+	"histcnt": "sve2", "histseg": "sve2", "match": "sve2", "nmatch": "sve2", "tbx": "sve2",
+	"whilege": "sve2", "whilegt": "sve2", "whilehi": "sve2", "whilehs": "sve2", "whilerw": "sve2", "whilewr": "sve2",
+	"adclb": "sve2", "adclt": "sve2", "sbclb": "sve2", "sbclt": "sve2", "pmullb": "sve2", "pmullt": "sve2",
+	"sqrdmlah": "sve2", "sqrdmlsh": "sve2", "saddlb": "sve2", "saddlt": "sve2", "uaddlb": "sve2", "uaddlt": "sve2",
+	"aese": "sve2-aes", "aesd": "sve2-aes", "aesmc": "sve2-aes", "aesimc": "sve2-aes",
+	"sm4e": "sve2-sm4", "sm4ekey": "sve2-sm4",
+	"rax1": "sve2-sha3",
+	"bdep": "sve2-bitperm", "bext": "sve2-bitperm", "bgrp": "sve2-bitperm",
+}
+
+// SVE vector (z) and predicate (p) register operands
+var regexpSVE = regexp.MustCompile(`(?i)\bz([0-9]|[12][0-9]|3[01])(\.[bhsdq])?\b|\bp([0-9]|1[0-5])(/[zm]|\.[bhsdq])?\b`)
+
+// requiresArm64 returns the ISA extensions needed to assemble an instruction
+func requiresArm64(instr string) []string {
+	text := normalize(instr)
+	mnemonic := strings.ToLower(strings.SplitN(text, " ", 2)[0])
+	if regexpSVE.MatchString(text) {
+		if ext, ok := sve2Instructions[mnemonic]; ok {
+			// Polynomial multiplies into 128-bit elements are part of AES
+			if ext == "sve2" && strings.HasPrefix(mnemonic, "pmull") && strings.Contains(strings.ToLower(text), ".q") {
+				ext = "sve2-aes"
+			}
+			return []string{ext}
+		}
+		return []string{"sve"}
+	}
+	if ext, ok := arm64Extensions[mnemonic]; ok {
+		return []string{ext}
+	}
+	return nil
+}
+
+// extensionsArm64 returns the directives enabling the extensions needed for an instruction
+func extensionsArm64(ins *Instruction) string {
+	exts := append(requiresArm64(ins.instruction), ins.extensions...)
+	directives := ""
+	for _, ext := range exts {
+		directives += ".arch_extension " + ext + "\n"
+	}
+	return directives
+}
+
+func gasArm64(instructions []Instruction, opts *Options) error {
+
+	app, err := gasCommand("arm64", opts.As)
+	if err != nil {
+		return err
+	}
+
+	order, groups := extensionGroups(instructions)
+	rejected := make(AssembleErrors, 0)
+	for _, directives := range order {
+		group := make([]Instruction, len(groups[directives]))
+		for j, i := range groups[directives] {
+			group[j] = instructions[i]
+		}
+		err = gasBatchArm64(app, marchFlag(opts), directives, group, opts.Filename)
+		if _, ok := err.(errBatch); ok {
+			// Could not split the output, assemble one by one instead
+			err = gasEachArm64(app, marchFlag(opts), group, opts.Jobs, opts.Filename)
+		}
+		if errs, ok := err.(AssembleErrors); ok {
+			rejected = append(rejected, errs...)
+		} else if err != nil {
+			return err
+		}
+		for j, i := range groups[directives] {
+			instructions[i].opcodes = group[j].opcodes
+		}
+	}
+	if len(rejected) > 0 {
+		rejected.sort()
+		return rejected
+	}
+	return nil
+}
+
+// extensionGroups groups the instructions by the directives enabling the
+// extensions they need, in order of appearance. An extension enabled for
+// one instruction would remain in effect for the instructions following
+// it, so every group is assembled on its own (as are single instructions).
+func extensionGroups(instructions []Instruction) ([]string, map[string][]int) {
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for i := range instructions {
+		directives := extensionsArm64(&instructions[i])
+		if _, ok := groups[directives]; !ok {
+			order = append(order, directives)
+		}
+		groups[directives] = append(groups[directives], i)
+	}
+	return order, groups
+}
+
+// gasBatchArm64 assembles all instructions in a single run of as, with the
+// directives enabling extensions in front of them
+func gasBatchArm64(app, march, directives string, instructions []Instruction, filename string) error {
+
+	tmpfile, err := ioutil.TempFile("", "asm2plan9s")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpfile.Write([]byte(directives)); err != nil {
+		return err
+	}
+	for i := range instructions {
+		if _, err := tmpfile.Write([]byte(gasLabelLine(i) + instructions[i].instruction + "\n")); err != nil {
+			return err
+		}
+	}
+	if _, err := tmpfile.Write([]byte(gasLabelLine(len(instructions)))); err != nil {
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+	// Instruction i follows the directives and its label
+	index := labelledIndex(strings.Count(directives, "\n")+2, len(instructions))
+
+	asmFile := tmpfile.Name() + ".asm"
+	lisFile := tmpfile.Name() + ".lis"
+	objFile := tmpfile.Name() + ".obj"
+	os.Rename(tmpfile.Name(), asmFile)
+
+	defer os.Remove(asmFile) // clean up
+	defer os.Remove(lisFile) // clean up
+	defer os.Remove(objFile) // clean up
+
+	cmd := exec.Command(app, march, listingContLines, "-o", objFile, fmt.Sprintf("-aln=%s", lisFile), asmFile)
+	cmb, err := runAssembler(cmd)
+	if err != nil {
+		if len(cmb) <= len(asmFile) { // command invocation failed
+			return fmt.Errorf("exec error: %v", err)
+		}
+		return gasDiagnostics.errors(string(cmb), asmFile, index, instructions, filename)
+	}
+
+	return fromGas(objFile, lisFile, index, instructions, cmb)
+}
+
+// gasEachArm64 assembles the instructions one at a time
+func gasEachArm64(app, march string, instructions []Instruction, jobs int, filename string) error {
+	return forEach(instructions, jobs, func(ctx context.Context, ins *Instruction) error {
+		opcodes, err := asSingle(ctx, app, march, extensionsArm64(ins), ins, filename)
+		if err != nil {
+			return err
+		}
+		ins.opcodes = make([]byte, len(opcodes))
+		copy(ins.opcodes[:], opcodes)
+		return nil
+	})
+}
+
+func asSingle(ctx context.Context, app, march, directives string, ins *Instruction, filename string) ([]byte, error) {
+
+	content := []byte(directives + gasLabelLine(0) + ins.instruction + "\n" + gasLabelLine(1))
+	tmpfile, err := ioutil.TempFile("", "asm2plan9s")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tmpfile.Write(content); err != nil {
+		return nil, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return nil, err
+	}
+
+	asmFile := tmpfile.Name() + ".asm"
+	lisFile := tmpfile.Name() + ".lis"
+	objFile := tmpfile.Name() + ".obj"
+	os.Rename(tmpfile.Name(), asmFile)
+
+	defer os.Remove(asmFile) // clean up
+	defer os.Remove(lisFile) // clean up
+	defer os.Remove(objFile) // clean up
+
+	// as -march=armv8-a+crypto -o first.out -al=first.lis first.s
+	arg0 := march
+	arg1 := "-o"
+	arg2 := objFile
+	arg3 := fmt.Sprintf("-al=%s", lisFile)
+	arg4 := asmFile
+
+	// The instruction follows the directives and its label
+	lineno := strings.Count(directives, "\n") + 2
+
+	cmd := exec.CommandContext(ctx, app, listingContLines, arg0, arg1, arg2, arg3, arg4)
+	cmb, err := runAssembler(cmd)
+	if err != nil {
+		if len(cmb) <= len(asmFile) { // command invocation failed
+			return nil, fmt.Errorf("exec error: %v", err)
+		}
+		return nil, gasDiagnostics.errors(string(cmb), asmFile, labelledIndex(lineno, 1), []Instruction{*ins}, filename)
+	}
+
+	single := []Instruction{*ins}
+	if err := fromGas(objFile, lisFile, labelledIndex(lineno, 1), single, cmb); err != nil {
+		return nil, err
+	}
+	return single[0].opcodes, nil
+}
+
+// toPlan9sArm64 converts the opcodes into a sequence of (32-bit) WORDs
+func toPlan9sArm64(opcodes []byte, instr string, commentPos int, inDefine bool) (string, error) {
+	if len(opcodes)%4 != 0 {
+		return "", fmt.Errorf("opcodes for '%s' are not a multiple of 4 bytes: %x", strings.TrimSpace(instr), opcodes)
+	}
+
+	sline := "    "
+	for i := 0; i < len(opcodes); i += 4 {
+		if i != 0 {
+			sline += "; "
+		}
+		sline += fmt.Sprintf("WORD $0x%02x%02x%02x%02x", opcodes[i+3], opcodes[i+2], opcodes[i+1], opcodes[i])
+	}
+
+	return appendInstruction(sline, instr, commentPos, inDefine), nil
+}
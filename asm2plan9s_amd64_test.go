@@ -86,11 +86,11 @@ func TestCompactMultipleInstructions(t *testing.T) {
 	ins11 := "    MOVQ BX, CX"
 	ins12 := "                                 // VPADDQ  XMM4,XMM5,XMM6"
 	ins13 := "                                 // VPADDQ  XMM5,XMM6,XMM0"
-	out0 := "    QUAD $0xd4e9c5c0d471c1c4; QUAD $0xd4d1c5e6d4d1c5cb; QUAD $0xd4d1c5e6d4d1c5e6; QUAD $0x71c1c4e6d4d1c5e6; WORD $0xc0d4"
+	out0 := "    QUAD $0xd4e9c5c0d471c1c4; QUAD $0xd4d1c5e6d4d1c5cb; QUAD $0xd4d1c5e6d4d1c5e6; LONG $0xd4d1c5e6; BYTE $0xe6"
 	out1 := "    MOVQ AX, BX"
-	out2 := "    QUAD $0xe6d4d1c5cbd4e9c5"
+	out2 := "    QUAD $0xd4e9c5c0d471c1c4; BYTE $0xcb"
 	out3 := "    MOVQ BX, CX"
-	out4 := "    LONG $0xe8d4c9c5"
+	out4 := "    QUAD $0xe8d4c9c5e6d4d1c5"
 	out := make([]string, 5)
 	out[0], out[1], out[2], out[3], out[4] = out0, out1, out2, out3, out4
 
@@ -124,8 +124,7 @@ func TestToPlan9sGasSingleLineEVEX(t *testing.T) {
    2      D4C0
    3              `
 
-	out := make([][]byte, 1, 1)
-	out[0] = []byte{98, 209, 245, 72, 212, 192}
+	out := map[int][]byte{2: {98, 209, 245, 72, 212, 192}}
 
 	tmpfile, err := ioutil.TempFile("", "test")
 	if err != nil {
@@ -141,7 +140,7 @@ func TestToPlan9sGasSingleLineEVEX(t *testing.T) {
 	defer os.Remove(tmpfile.Name()) // clean up
 
 	result, _ := toPlan9sGas(tmpfile.Name())
-	if len(result) != len(out) || !bytes.Equal(result[0], out[0]) {
+	if len(result) != len(out) || !bytes.Equal(result[2], out[2]) {
 		t.Errorf("expected %v\ngot                     %v", out, result)
 	}
 }
@@ -157,10 +156,11 @@ func TestToPlan9sGasMultiLinesVEX(t *testing.T) {
    4      D2
    5          `
 
-	out := make([][]byte, 3, 3)
-	out[0] = []byte{196, 193, 113, 212, 192}
-	out[1] = []byte{196, 193, 105, 212, 201}
-	out[2] = []byte{196, 193, 97, 212, 210}
+	out := map[int][]byte{
+		2: {196, 193, 113, 212, 192},
+		3: {196, 193, 105, 212, 201},
+		4: {196, 193, 97, 212, 210},
+	}
 
 	tmpfile, err := ioutil.TempFile("", "test")
 	if err != nil {
@@ -176,8 +176,119 @@ func TestToPlan9sGasMultiLinesVEX(t *testing.T) {
 	defer os.Remove(tmpfile.Name()) // clean up
 
 	result, _ := toPlan9sGas(tmpfile.Name())
-	if len(result) != len(out) || !bytes.Equal(result[0], out[0]) ||
-		!bytes.Equal(result[1], out[1]) || !bytes.Equal(result[2], out[2]) {
+	if len(result) != len(out) || !bytes.Equal(result[2], out[2]) ||
+		!bytes.Equal(result[3], out[3]) || !bytes.Equal(result[4], out[4]) {
 		t.Errorf("expected %v\ngot                     %v", out, result)
 	}
 }
+
+func TestGasErrorsAmd64(t *testing.T) {
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ ZMM0, ZMM1, ZMM2", lineno: 9},
+		{instruction: " FOO RAX", lineno: 10, col: 69},
+		{instruction: " MOV RAX, RBX, RCX /* too many */", lineno: 14, col: 69},
+	}
+	output := `/tmp/asm2plan9s1.asm: Assembler messages:
+/tmp/asm2plan9s1.asm:3: Error: no such instruction: ` + "`foo rax'" + `
+/tmp/asm2plan9s1.asm:4: Warning: ignoring operand
+/tmp/asm2plan9s1.asm:4: Error: number of operands mismatch for ` + "`mov'"
+
+	err := gasDiagnostics.errors(output, "/tmp/asm2plan9s1.asm", func(line int) int { return line - 2 }, instructions, "sha256block_amd64.s")
+	if _, ok := err.(AssembleErrors); !ok {
+		t.Fatalf("expected error per instruction, got %v", err)
+	}
+
+	out := "sha256block_amd64.s:11:69: 'FOO RAX': no such instruction: `foo rax'\n" +
+		"sha256block_amd64.s:15:69: 'MOV RAX, RBX, RCX /* too many */': number of operands mismatch for `mov'"
+	if err.Error() != out {
+		t.Errorf("expected %s\ngot                     %s", out, err.Error())
+	}
+}
+
+func TestYasmErrorsAmd64(t *testing.T) {
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ XMM0, XMM1, XMM8", lineno: 9, col: 69},
+		{instruction: " FOO RAX", lineno: 10, col: 69},
+	}
+	output := `/tmp/asm2plan9s1.asm:3: warning: value does not fit in 8 bit field
+/tmp/asm2plan9s1.asm:5: error: instruction expected after label`
+
+	// Instruction i is on line 2i+3
+	err := yasmDiagnostics.errors(output, "/tmp/asm2plan9s1.asm", labelledIndex(3, len(instructions)), instructions, "sha256block_amd64.s")
+	if _, ok := err.(AssembleErrors); !ok {
+		t.Fatalf("expected error per instruction, got %v", err)
+	}
+	out := "sha256block_amd64.s:11:69: 'FOO RAX': instruction expected after label"
+	if err.Error() != out {
+		t.Errorf("expected %s\ngot                     %s", out, err.Error())
+	}
+
+	// Output that does not point at an instruction fails the whole batch
+	err = yasmDiagnostics.errors("/tmp/asm2plan9s1.asm:4: error: oops", "/tmp/asm2plan9s1.asm", labelledIndex(3, len(instructions)), instructions, "sha256block_amd64.s")
+	if _, ok := err.(errBatch); !ok || err.Error() != "YASM error: /tmp/asm2plan9s1.asm:4: error: oops" {
+		t.Errorf("expected batch error, got %v", err)
+	}
+}
+
+func TestToPlan9sGasMalformed(t *testing.T) {
+
+	ins := `   1                    .intel_syntax noprefix
+   2 0000 C4C171D4       VPADDQ  XMM0,XMM1,XMM8
+   2      C0D
+`
+
+	tmpfile, err := ioutil.TempFile("", "test")
+	if err != nil {
+		return
+	}
+
+	if _, err := tmpfile.Write([]byte(ins)); err != nil {
+		return
+	}
+	if err := tmpfile.Close(); err != nil {
+		return
+	}
+	defer os.Remove(tmpfile.Name()) // clean up
+
+	_, err = toPlan9sGas(tmpfile.Name())
+	if e, ok := err.(*AssembleError); !ok || e.Err == nil {
+		t.Errorf("expected *AssembleError with a cause\ngot                     %v", err)
+	}
+}
+
+func TestFromListing(t *testing.T) {
+
+	instructions := []Instruction{
+		{instruction: " loop:", lineno: 3},
+		{instruction: " twice rax", lineno: 4},
+		{instruction: " NOP", lineno: 5},
+	}
+	opcodes := map[int][]byte{
+		3: {0x48, 0x83, 0xc0, 0x01, 0x48, 0x83, 0xc0, 0x01},
+		4: {0x90},
+	}
+
+	// Instruction i is on line i+2
+	index := func(line int) int {
+		if line < 2 || line-2 >= len(instructions) {
+			return -1
+		}
+		return line - 2
+	}
+	if err := fromListing(opcodes, index, instructions); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := [][]byte{{}, opcodes[3], opcodes[4]}
+	for i, ins := range instructions {
+		if ins.opcodes == nil || !bytes.Equal(ins.opcodes, out[i]) {
+			t.Errorf("expected %x\ngot                     %x", out[i], ins.opcodes)
+		}
+	}
+
+	opcodes[1] = []byte{0x90}
+	if _, ok := fromListing(opcodes, index, instructions).(*AssembleError); !ok {
+		t.Errorf("expected *AssembleError for opcodes outside of an instruction")
+	}
+}
@@ -0,0 +1,331 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaleLines(t *testing.T) {
+
+	lines := []string{
+		"    MOVQ AX, BX",
+		"    LONG $0x003377bb; BYTE $0xff // VPADDQ  XMM0,XMM1,XMM8",
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8",
+		`    LONG $0x00000000; BYTE $0xdd \ // VPADDQ  XMM0,XMM1,XMM8`,
+		"\tLONG $0xd471c1c4; BYTE $0xc0\t// VPADDQ  XMM0,XMM1,XMM8",
+	}
+	result := []string{
+		"    MOVQ AX, BX",
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8",
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8",
+		`    LONG $0xd471c1c4; BYTE $0xc0 \ // VPADDQ  XMM0,XMM1,XMM8`,
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ XMM0, XMM1, XMM8",
+	}
+
+	stale := staleLines(lines, result)
+	if len(stale) != 2 {
+		t.Fatalf("expected 2 stale lines\ngot                     %d", len(stale))
+	}
+	if stale[0].lineno != 1 || stale[0].have != "LONG $0x003377bb; BYTE $0xff" || stale[0].want != "LONG $0xd471c1c4; BYTE $0xc0" {
+		t.Errorf("unexpected stale line %+v", stale[0])
+	}
+	if stale[1].lineno != 3 || stale[1].have != "LONG $0x00000000; BYTE $0xdd" {
+		t.Errorf("unexpected stale line %+v", stale[1])
+	}
+}
+
+func TestCombineLines(t *testing.T) {
+
+	lines := []string{
+		"                                 // VPADDQ  XMM0,XMM1,XMM8",
+		"                                 // VPADDQ  XMM1,XMM2,XMM3",
+		"",
+		"    // comment",
+		"                                 // VPADDQ  XMM4,XMM5,XMM6",
+		"    MOVQ AX, BX",
+		"                                 // VPADDQ  XMM5,XMM6,XMM0",
+	}
+	instructions := func() []Instruction {
+		return []Instruction{
+			{lineno: 0, opcodes: []byte{0xc4, 0xc1, 0x71, 0xd4, 0xc0}},
+			{lineno: 1, opcodes: []byte{0xc5, 0xe9, 0xd4, 0xcb}},
+			{lineno: 4, opcodes: []byte{0xc5, 0xd1, 0xd4, 0xe6}, inRegion: true},
+			{lineno: 6, opcodes: []byte{0xc5, 0xc9, 0xd4, 0xe8}, inRegion: true},
+		}
+	}
+
+	testCases := []struct {
+		compact CompactOptions
+		out     []string
+	}{
+		{CompactOptions{Enabled: true}, []string{
+			"    QUAD $0xd4e9c5c0d471c1c4; BYTE $0xcb",
+			"    LONG $0xe6d4d1c5",
+			"    LONG $0xe8d4c9c5",
+		}},
+		{CompactOptions{Enabled: true, CrossBlank: true}, []string{
+			"    QUAD $0xd4e9c5c0d471c1c4; LONG $0xd4d1c5cb; BYTE $0xe6",
+			"    LONG $0xe8d4c9c5",
+		}},
+		{CompactOptions{Enabled: true, CrossBlank: true, MaxBytes: 9}, []string{
+			"    QUAD $0xd4e9c5c0d471c1c4; BYTE $0xcb",
+			"    LONG $0xe6d4d1c5",
+			"    LONG $0xe8d4c9c5",
+		}},
+		{CompactOptions{Enabled: true, CrossBlank: true, Regions: true}, []string{
+			"    LONG $0xd471c1c4; BYTE $0xc0",
+			"    LONG $0xcbd4e9c5",
+			"    LONG $0xe6d4d1c5",
+			"    LONG $0xe8d4c9c5",
+		}},
+	}
+
+	for i, tc := range testCases {
+		a := Assembler{Instructions: instructions(), Compact: tc.compact, arch: archAmd64}
+		for j := range a.Instructions {
+			a.Instructions[j].assembled, _ = toPlan9s(a.Instructions[j].opcodes, "", 0, false)
+		}
+		a.combineLines(lines)
+		if len(a.Instructions) != len(tc.out) {
+			t.Errorf("test %d: expected length %d\ngot             length %d", i, len(tc.out), len(a.Instructions))
+			continue
+		}
+		for j := range a.Instructions {
+			if a.Instructions[j].assembled != tc.out[j] {
+				t.Errorf("test %d: expected %s\ngot                     %s", i, tc.out[j], a.Instructions[j].assembled)
+			}
+		}
+	}
+}
+
+func TestAssembleMissingEntry(t *testing.T) {
+
+	lines := []string{
+		"    MOVQ AX, BX",
+		"                                 // VPADDQ  XMM0,XMM1,XMM8",
+	}
+
+	a := Assembler{arch: archAmd64}
+	_, err := a.assemble(lines)
+	e, ok := err.(*AssembleError)
+	if !ok {
+		t.Fatalf("expected *AssembleError\ngot                     %v", err)
+	}
+	if e.Line != 2 || e.Col != 37 || e.Instruction != "VPADDQ  XMM0,XMM1,XMM8" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestDetectByteSequence(t *testing.T) {
+
+	lines := make([]string, 0)
+	for n := 1; n <= maxInstructionLength; n++ {
+		opcodes := make([]byte, n)
+		for i := range opcodes {
+			opcodes[i] = byte(0x11 * (i + 1))
+		}
+		// Comment right after the sequence, aligned at column 65, and in a #define
+		for _, commentPos := range []int{0, 65} {
+			for _, inDefine := range []bool{false, true} {
+				line, _ := toPlan9s(opcodes, " INSTR", commentPos, inDefine)
+				lines = append(lines, line)
+			}
+		}
+		// First run, behind the imaginary sequence
+		for _, w := range sequenceWidths(n) {
+			lines = append(lines, strings.Repeat(" ", w+1)+"// INSTR")
+		}
+	}
+	for _, words := range []int{1, 2, 3} {
+		line, _ := toPlan9sArm64(make([]byte, 4*words), " INSTR", 0, false)
+		lines = append(lines, line)
+	}
+
+	a := Assembler{Prescan: true, arch: archAmd64}
+	a.assemble(lines)
+	seen := make(map[int]bool)
+	for _, ins := range a.Instructions {
+		seen[ins.lineno] = true
+	}
+	for i, line := range lines {
+		if !seen[i] {
+			t.Errorf("expected instruction\ngot                     %s", line)
+		}
+	}
+
+	for _, line := range []string{
+		"    // comment",
+		"    MOVQ AX, BX // comment",
+		"    LONG $0x0011 // comment",
+		"    BYTE $0x00; MOVQ AX, BX // comment",
+		"    LONG $0x00112233;BYTE $0x44 // comment",
+	} {
+		if detect(line, "").instruction {
+			t.Errorf("unexpected instruction %s", line)
+		}
+	}
+}
+
+func TestMarker(t *testing.T) {
+
+	lines := []string{
+		"\t//asm: VPADDQ  XMM0,XMM1,XMM8",
+		"    LONG $0x0; BYTE $0x0  //asm: VPADDQ  XMM0,XMM1,XMM8",
+		`        \ //asm:VZEROUPPER`,
+		"    // asm: VPADDQ  XMM0,XMM1,XMM8",
+		"    //asmfmt: VPADDQ  XMM0,XMM1,XMM8",
+	}
+
+	testCases := []struct {
+		marker string
+		want   []Instruction
+	}{
+		{"asm:", []Instruction{
+			{lineno: 0, instruction: " VPADDQ  XMM0,XMM1,XMM8", marker: "asm:", commentPos: 4, col: 9},
+			{lineno: 2, instruction: "VZEROUPPER", marker: "asm:", commentPos: 10, col: 17, inDefine: true},
+		}},
+		{"asmfmt:", []Instruction{
+			{lineno: 4, instruction: " VPADDQ  XMM0,XMM1,XMM8", marker: "asmfmt:", commentPos: 4, col: 15},
+		}},
+		{"", []Instruction{}},
+	}
+
+	for _, tc := range testCases {
+		a := Assembler{Prescan: true, Marker: tc.marker, arch: archAmd64}
+		a.assemble(lines)
+		if len(a.Instructions) != len(tc.want) {
+			t.Errorf("%q: expected %d instructions\ngot                     %d", tc.marker, len(tc.want), len(a.Instructions))
+			continue
+		}
+		for i, want := range tc.want {
+			got := a.Instructions[i]
+			if got.lineno != want.lineno || got.instruction != want.instruction || got.marker != want.marker ||
+				got.commentPos != want.commentPos || got.col != want.col || got.inDefine != want.inDefine {
+				t.Errorf("%q: expected %+v\ngot                     %+v", tc.marker, want, got)
+			}
+		}
+	}
+}
+
+func TestReadWriteLines(t *testing.T) {
+
+	testCases := []struct {
+		in    string
+		lines int
+	}{
+		{"", 0},
+		{"\n", 1},
+		{"    MOVQ AX, BX\n    RET\n", 2},
+		{"    MOVQ AX, BX\n    RET", 2},
+		{"    MOVQ AX, BX\r\n\r\n    RET\r\n", 3},
+		{"    MOVQ AX, BX\r\n    RET", 2},
+	}
+
+	for _, tc := range testCases {
+		lines, newline, err := readLines("", strings.NewReader(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != tc.lines {
+			t.Errorf("%q: expected %d lines\ngot                     %d", tc.in, tc.lines, len(lines))
+		}
+		var out bytes.Buffer
+		if err := writeLines(lines, newline, "", &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tc.in {
+			t.Errorf("expected %q\ngot                     %q", tc.in, out.String())
+		}
+	}
+}
+
+func TestLossless(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lossless_amd64.s"+lockSuffix)
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ  XMM0,XMM1,XMM8", backend: "gas", opcodes: []byte{0xc4, 0xc1, 0x71, 0xd4, 0xc0}},
+		{instruction: " NOP", backend: "gas", opcodes: []byte{0x90}},
+	}
+	if err := writeLockfile(path, instructions, archAmd64, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	in := "#define X \\\r\n" +
+		"\tMOVQ AX,\tBX // keep\ttabs  \r\n" +
+		strings.Repeat(" ", 33) + "// VPADDQ  XMM0,XMM1,XMM8\t// note\twith tab\r\n" +
+		"\tLONG $0xd471c1c4; BYTE $0xc0\t// VPADDQ  XMM0,XMM1,XMM8\r\n" +
+		"    h\u00e9llo w\u00f6rld //asm: NOP\r\n" +
+		"\t//asm: NOP // h\u00e9llo w\u00f6rld\r\n" +
+		"    LONG $0x00000000; BYTE $0x00\t// VPADDQ  XMM0,XMM1,XMM8\r\n" +
+		"\tBYTE $0x00 \t  \\ // VPADDQ  XMM0,XMM1,XMM8  \r\n" +
+		"\t// plain \t comment"
+	out := "#define X \\\r\n" +
+		"\tMOVQ AX,\tBX // keep\ttabs  \r\n" +
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8\t// note\twith tab\r\n" +
+		"\tLONG $0xd471c1c4; BYTE $0xc0\t// VPADDQ  XMM0,XMM1,XMM8\r\n" +
+		"    h\u00e9llo w\u00f6rld //asm: NOP\r\n" +
+		"\tBYTE $0x90 //asm: NOP // h\u00e9llo w\u00f6rld\r\n" +
+		"    LONG $0xd471c1c4; BYTE $0xc0\t// VPADDQ  XMM0,XMM1,XMM8\r\n" +
+		"\tLONG $0xd471c1c4; BYTE $0xc0 \t  \\ // VPADDQ  XMM0,XMM1,XMM8  \r\n" +
+		"\t// plain \t comment"
+
+	lines, newline, err := readLines("", strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := assembleWith(lines, Options{Filename: "lossless_amd64.s", Verify: true, Lockfile: path, Marker: exampleMarker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeLines(result, newline, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != out {
+		t.Errorf("expected %q\ngot                     %q", out, buf.String())
+	}
+}
+
+func TestMarchFromHeader(t *testing.T) {
+
+	testCases := []struct {
+		lines []string
+		march string
+	}{
+		{[]string{"//go:build arm64", "", "//asm2plan9s:march armv8.2-a+sha3", "//asm2plan9s:march armv8.4-a", "TEXT ·f(SB), 7, $0"}, "armv8.2-a+sha3"},
+		{[]string{"// header", "TEXT ·f(SB), 7, $0", "//asm2plan9s:march armv8.2-a+sha3"}, ""},
+		{[]string{"#include \"textflag.h\"", "//asm2plan9s:march armv8.2-a+sha3"}, ""},
+	}
+
+	for i, tc := range testCases {
+		if march := marchFromHeader(tc.lines); march != tc.march {
+			t.Errorf("test %d: expected %q\ngot                     %q", i, tc.march, march)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+// See below for YASM support (older, no AVX512)
+
+///////////////////////////////////////////////////////////////////////////////
+//
+// G A S   S U P P O R T
+//
+///////////////////////////////////////////////////////////////////////////////
+
+//
+// frank@hemelmeer: asm2plan9s$ more example.s
+// .intel_syntax noprefix
+//
+//     VPANDQ   ZMM0, ZMM1, ZMM2
+//
+// frank@hemelmeer: asm2plan9s$ as -o example.o -al=example.lis example.s
+// frank@hemelmeer: asm2plan9s$ more example.lis
+// GAS LISTING example.s                   page 1
+// 1                    .intel_syntax noprefix
+// 2
+// 3 0000 62F1F548          VPANDQ   ZMM0, ZMM1, ZMM2
+// 3      DBC2
+//
+
+func gasVersionAmd64(opts *Options) (string, error) {
+	return gasIdentity("amd64", opts)
+}
+
+func gasAmd64(instructions []Instruction, opts *Options) error {
+
+	app, err := gasCommand("amd64", opts.As)
+	if err != nil {
+		return err
+	}
+
+	tmpfile, err := ioutil.TempFile("", "asm2plan9s")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpfile.Write([]byte(fmt.Sprintf(".intel_syntax noprefix\n"))); err != nil {
+		return err
+	}
+
+	for i, instr := range instructions {
+		content := []byte(gasLabelLine(i) + instr.instruction + "\n")
+
+		if _, err := tmpfile.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	if _, err := tmpfile.Write([]byte(gasLabelLine(len(instructions)))); err != nil {
+		return err
+	}
+
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+
+	asmFile := tmpfile.Name() + ".asm"
+	lisFile := tmpfile.Name() + ".lis"
+	objFile := tmpfile.Name() + ".obj"
+	os.Rename(tmpfile.Name(), asmFile)
+
+	defer os.Remove(asmFile) // clean up
+	defer os.Remove(lisFile) // clean up
+	defer os.Remove(objFile) // clean up
+
+	// as -o example.o -al=example.lis example.s
+	arg0 := "-o"
+	arg1 := objFile
+	arg2 := fmt.Sprintf("-aln=%s", lisFile)
+	arg3 := asmFile
+
+	// Instruction i is on line 2i+3 (after .intel_syntax and its label)
+	index := labelledIndex(3, len(instructions))
+
+	cmd := exec.Command(app, listingContLines, arg0, arg1, arg2, arg3)
+	cmb, err := runAssembler(cmd)
+	if err != nil {
+		if len(cmb) <= len(asmFile) { // command invocation failed
+			return fmt.Errorf("exec error: %v", err)
+		}
+		return gasDiagnostics.errors(string(cmb), asmFile, index, instructions, opts.Filename)
+	}
+
+	return fromGas(objFile, lisFile, index, instructions, cmb)
+}
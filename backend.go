@@ -0,0 +1,373 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// backend is an external assembler that is able to
+// fill in the opcodes for a list of instructions
+type backend struct {
+	name    string
+	as      func(instructions []Instruction, opts *Options) error
+	version func(opts *Options) (string, error) // identifies the assembler, its version and flags
+}
+
+// assemble assembles the instructions with the backend,
+// consulting the cache first when it is enabled
+func (b backend) assemble(instructions []Instruction, arch *arch, opts *Options) error {
+	if opts.CacheDir == "" {
+		return b.as(instructions, opts)
+	}
+	return asCached(instructions, arch, b, opts)
+}
+
+// Name of the backend that tries all backends in order of preference
+const backendAuto = "auto"
+
+// backendError records why a backend failed to assemble the instructions
+type backendError struct {
+	backend string
+	err     error
+}
+
+func (e backendError) Error() string {
+	return fmt.Sprintf("%s: %v", e.backend, e.err)
+}
+
+// Unwrap returns the error of the backend
+func (e backendError) Unwrap() error { return e.err }
+
+// backendErrors is returned when none of the backends tried succeeded
+type backendErrors []backendError
+
+func (e backendErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return "all backends failed:\n\t" + strings.Join(msgs, "\n\t")
+}
+
+// Unwrap returns the errors of the backends, so that errors.As finds
+// eg. an *AssembleError reported by one of them
+func (e backendErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i := range e {
+		errs[i] = e[i]
+	}
+	return errs
+}
+
+// errBatch is returned when the output of a batched run cannot be mapped
+// back to the individual instructions
+type errBatch string
+
+func (e errBatch) Error() string { return string(e) }
+
+// procs limits the number of assembler processes that run at the same
+// time, across all files that are processed concurrently (-j)
+var procs = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// setMaxProcs sets the number of assembler processes that can run at the
+// same time (GOMAXPROCS when n is not positive). It must be called before
+// any assembler is run.
+func setMaxProcs(n int) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	procs = make(chan struct{}, n)
+}
+
+// acquireProc waits until another assembler process may be started, and
+// returns the function to call once it has finished
+func acquireProc() (release func()) {
+	procs <- struct{}{}
+	return func() { <-procs }
+}
+
+// runAssembler runs cmd when the number of assembler processes allows
+// it, and returns its combined standard output and standard error
+func runAssembler(cmd *exec.Cmd) ([]byte, error) {
+	release := acquireProc()
+	defer release()
+	return cmd.CombinedOutput()
+}
+
+// forEach calls fn for every instruction using up to jobs concurrent
+// workers (GOMAXPROCS when jobs is not positive). The first error
+// cancels the context passed to fn, stops handing out the remaining
+// instructions and is returned. AssembleErrors are collected instead,
+// and returned together once all instructions have been tried.
+func forEach(instructions []Instruction, jobs int, fn func(ctx context.Context, ins *Instruction) error) error {
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+		mu    sync.Mutex
+		errs  AssembleErrors
+	)
+	indices := make(chan int)
+	for w := 0; w < jobs && w < len(instructions); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				err := fn(ctx, &instructions[i])
+				if e, ok := err.(AssembleErrors); ok {
+					mu.Lock()
+					errs = append(errs, e...)
+					mu.Unlock()
+				} else if err != nil {
+					once.Do(func() {
+						first = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range instructions {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if first == nil && len(errs) > 0 {
+		errs.sort()
+		return errs
+	}
+	return first
+}
+
+// backendNames returns the names that can be used to select a backend
+func backendNames() []string {
+	names := []string{backendAuto}
+	for _, a := range archs {
+		for _, b := range a.backends {
+			if !containsString(names, b.name) {
+				names = append(names, b.name)
+			}
+		}
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validBackend checks whether name selects a known backend
+func validBackend(name string) bool {
+	return containsString(backendNames(), name)
+}
+
+// assembleAll assembles as many of the instructions as possible with the
+// backend. When the backend rejects some of the instructions, it is run
+// again without them. The errors for the rejected instructions are returned,
+// err is only set when the backend failed as a whole.
+func (b backend) assembleAll(instructions []Instruction, arch *arch, opts *Options) (errs AssembleErrors, err error) {
+
+	batch := make([]Instruction, len(instructions))
+	copy(batch, instructions)
+	for len(batch) > 0 {
+		err := b.assemble(batch, arch, opts)
+		if err == nil {
+			break
+		}
+		if e, ok := err.(*AssembleError); ok && e.Backend == "" {
+			e.Backend = b.name
+		}
+		rejected, ok := err.(AssembleErrors)
+		if !ok {
+			return nil, err
+		}
+		lines := make(map[int]bool)
+		for _, e := range rejected {
+			e.Backend = b.name
+			lines[e.Line-1] = true
+		}
+		rest := make([]Instruction, 0, len(batch))
+		for _, ins := range batch {
+			if !lines[ins.lineno] {
+				rest = append(rest, ins)
+			}
+		}
+		if len(rest) == len(batch) {
+			return nil, err // the errors do not match any of the instructions
+		}
+		errs, batch = append(errs, rejected...), rest
+	}
+
+	opcodes := make(map[int][]byte, len(batch))
+	for _, ins := range batch {
+		opcodes[ins.lineno] = ins.opcodes
+	}
+	for i := range instructions {
+		if op, ok := opcodes[instructions[i].lineno]; ok {
+			instructions[i].opcodes = op
+			instructions[i].backend = b.name
+		}
+	}
+	errs.sort()
+	return errs, nil
+}
+
+// as assembles the instructions for the architecture using the named
+// backend, recording the backend used in every instruction.
+//
+// In auto mode every instruction is routed to the first backend, in order
+// of preference, that is installed and capable of encoding it (eg. EVEX
+// instructions go to GAS as YASM does not support AVX512). When a backend
+// fails or rejects instructions these fall through to the next capable
+// backend. The errors of the backends that failed, and the rejections of
+// instructions that another backend did encode, are returned as well.
+//
+// Instructions rejected by all backends are left without a backend, and
+// reported together as AssembleErrors holding the error of every backend
+// that rejected them.
+func as(instructions []Instruction, arch *arch, opts *Options) (backendErrors, error) {
+
+	name := opts.Backend
+	if name != "" && name != backendAuto {
+		for _, b := range arch.backends {
+			if b.name == name {
+				errs, err := b.assembleAll(instructions, arch, opts)
+				if err != nil {
+					return nil, backendErrors{{backend: b.name, err: err}}
+				}
+				if len(errs) > 0 {
+					return nil, errs
+				}
+				return nil, nil
+			}
+		}
+		if validBackend(name) {
+			return nil, fmt.Errorf("backend %s does not support %s", name, arch.name)
+		}
+		return nil, fmt.Errorf("unknown backend %q (use one of %s)", name, strings.Join(backendNames(), ", "))
+	}
+
+	failed := make(backendErrors, 0, len(arch.backends))
+	rejected := make(map[int]AssembleErrors)
+	remaining := make([]int, len(instructions))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	for _, b := range arch.backends {
+		if len(remaining) == 0 {
+			break
+		}
+		if _, err := b.version(opts); err != nil {
+			failed = append(failed, backendError{backend: b.name, err: err})
+			continue
+		}
+
+		mine, rest := make([]int, 0, len(remaining)), make([]int, 0)
+		for _, i := range remaining {
+			if b.capable(arch, instructions[i].instruction, opts) {
+				mine = append(mine, i)
+			} else {
+				rest = append(rest, i)
+			}
+		}
+		if len(mine) == 0 {
+			continue
+		}
+
+		batch := make([]Instruction, len(mine))
+		for j, i := range mine {
+			batch[j] = instructions[i]
+		}
+		errs, err := b.assembleAll(batch, arch, opts)
+		if err != nil {
+			failed = append(failed, backendError{backend: b.name, err: err})
+			continue
+		}
+		for _, e := range errs {
+			for _, i := range mine {
+				if instructions[i].lineno == e.Line-1 {
+					rejected[i] = append(rejected[i], e)
+				}
+			}
+		}
+		for j, i := range mine {
+			if batch[j].backend == "" {
+				rest = append(rest, i)
+				continue
+			}
+			instructions[i].opcodes = batch[j].opcodes
+			instructions[i].backend = b.name
+			// Keep why the backends tried before rejected it
+			for _, e := range rejected[i] {
+				failed = append(failed, backendError{backend: e.Backend, err: e})
+			}
+			delete(rejected, i)
+		}
+		sort.Ints(rest)
+		remaining = rest
+	}
+
+	if len(remaining) > 0 {
+		errs, all := make(AssembleErrors, 0, len(remaining)), true
+		for _, i := range remaining {
+			if e, ok := rejected[i]; ok {
+				errs = append(errs, e...)
+			} else {
+				all = false
+			}
+		}
+		if all {
+			return failed, errs
+		}
+		if len(failed) > 0 {
+			return nil, failed
+		}
+		ins := instructions[remaining[0]]
+		return nil, fmt.Errorf("no backend for %s is capable of encoding '%s' (requires %s)", arch.name, strings.TrimSpace(ins.instruction), strings.Join(arch.requires(ins.instruction), ", "))
+	}
+	return failed, nil
+}
@@ -0,0 +1,230 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEach(t *testing.T) {
+
+	instructions := make([]Instruction, 100)
+	for i := range instructions {
+		instructions[i].lineno = i
+	}
+
+	err := forEach(instructions, 8, func(ctx context.Context, ins *Instruction) error {
+		ins.opcodes = []byte{byte(ins.lineno)}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, ins := range instructions {
+		if len(ins.opcodes) != 1 || ins.opcodes[0] != byte(i) {
+			t.Errorf("expected opcode %d\ngot                     %v", i, ins.opcodes)
+		}
+	}
+}
+
+func TestForEachError(t *testing.T) {
+
+	instructions := make([]Instruction, 1000)
+	for i := range instructions {
+		instructions[i].lineno = i
+	}
+
+	errFailed := errors.New("failed")
+	var calls int32
+	err := forEach(instructions, 4, func(ctx context.Context, ins *Instruction) error {
+		atomic.AddInt32(&calls, 1)
+		switch {
+		case ins.lineno < 10:
+			return nil
+		case ins.lineno == 10:
+			return errFailed
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != errFailed {
+		t.Errorf("expected %v\ngot                     %v", errFailed, err)
+	}
+	if calls > 20 {
+		t.Errorf("expected outstanding work to be cancelled, got %d calls", calls)
+	}
+}
+
+// rejecting returns a backend that rejects the instructions containing bad
+func rejecting(name, bad string, runs *int) backend {
+	return backend{
+		name: name,
+		as: func(instructions []Instruction, opts *Options) error {
+			*runs++
+			errs := make(AssembleErrors, 0)
+			for i := range instructions {
+				if strings.Contains(instructions[i].instruction, bad) {
+					errs = append(errs, newAssembleError(&instructions[i], opts.Filename, "no such instruction"))
+				}
+				instructions[i].opcodes = []byte{byte(instructions[i].lineno)}
+			}
+			if len(errs) > 0 {
+				return errs
+			}
+			return nil
+		},
+		version: func(opts *Options) (string, error) { return name, nil },
+	}
+}
+
+func TestAsRejected(t *testing.T) {
+
+	var first, second int
+	arch := &arch{name: "test", backends: []backend{rejecting("first", "BAD", &first), rejecting("second", "BAD2", &second)}}
+
+	instructions := []Instruction{
+		{instruction: " NOP", lineno: 1},
+		{instruction: " BAD1", lineno: 2},
+		{instruction: " BAD2", lineno: 3},
+		{instruction: " NOP", lineno: 4},
+	}
+	failed, err := as(instructions, arch, &Options{Filename: "test.s"})
+	errs, ok := err.(AssembleErrors)
+	if !ok || errs.instructions() != 1 {
+		t.Fatalf("expected a single rejected instruction, got %v", err)
+	}
+	// Every backend that rejected the instruction is reported
+	out := "test.s:4:0: first: 'BAD2': no such instruction\n" +
+		"test.s:4:0: second: 'BAD2': no such instruction"
+	if errs.Error() != out {
+		t.Errorf("expected %s\ngot                     %s", out, errs.Error())
+	}
+	// As is why BAD1 fell back to the second backend
+	out = "first: test.s:3:0: first: 'BAD1': no such instruction"
+	if len(failed) != 1 || failed.Error() != out {
+		t.Errorf("expected %s\ngot                     %v", out, failed)
+	}
+
+	backends := []string{"first", "second", "", "first"}
+	for i, ins := range instructions {
+		if ins.backend != backends[i] {
+			t.Errorf("expected backend %q for line %d\ngot                     %q", backends[i], ins.lineno, ins.backend)
+		}
+	}
+	if first != 2 || second != 2 {
+		t.Errorf("expected every backend to run twice, got %d and %d runs", first, second)
+	}
+}
+
+func TestAsUnwrap(t *testing.T) {
+
+	// Backends that fail on output they cannot make sense of
+	malformed := func(name string) backend {
+		return backend{
+			name: name,
+			as: func(instructions []Instruction, opts *Options) error {
+				return &AssembleError{Msg: "malformed listing line"}
+			},
+			version: func(opts *Options) (string, error) { return name, nil },
+		}
+	}
+	arch := &arch{name: "test", backends: []backend{malformed("first"), malformed("second")}}
+
+	instructions := []Instruction{{instruction: " NOP", lineno: 1}}
+	_, err := as(instructions, arch, &Options{Filename: "test.s"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var e *AssembleError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected *AssembleError in %v", err)
+	}
+	if e.Backend != "first" || e.Msg != "malformed listing line" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestAsFallback(t *testing.T) {
+
+	missing := backend{
+		name:    "missing",
+		as:      func(instructions []Instruction, opts *Options) error { return nil },
+		version: func(opts *Options) (string, error) { return "", errors.New("not installed") },
+	}
+	var runs int
+	defer func(b []backend) { archAmd64.backends = b }(archAmd64.backends)
+	archAmd64.backends = []backend{missing, rejecting("fallback", "BAD", &runs)}
+
+	lines := []string{
+		"TEXT ·foo(SB), 7, $0",
+		"                                 // NOP",
+		"    RET",
+	}
+	for _, verbose := range []bool{false, true} {
+		var log bytes.Buffer
+		_, err := assembleWith(lines, Options{Filename: "foo_amd64.s", Log: &log, Verbose: verbose})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The backend fallen back to is always named, why only with -v
+		if !strings.Contains(log.String(), "foo_amd64.s: assembled for amd64 with fallback: lines 2\n") {
+			t.Errorf("verbose %v: backend not reported in %q", verbose, log.String())
+		}
+		failed := strings.Contains(log.String(), "missing backend failed: not installed")
+		if failed != verbose {
+			t.Errorf("verbose %v: unexpected log output %q", verbose, log.String())
+		}
+	}
+}
+
+func TestMaxProcs(t *testing.T) {
+
+	defer func(p chan struct{}) { procs = p }(procs)
+	setMaxProcs(2)
+
+	// Several files running their assemblers at once
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := acquireProc()
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("expected at most 2 assembler processes at a time, got %d", most)
+	}
+}
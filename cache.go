@@ -0,0 +1,201 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultCacheDir returns the directory used to cache encodings,
+// $XDG_CACHE_HOME/asm2plan9s (or the platform equivalent)
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "asm2plan9s")
+}
+
+// normalize returns the instruction text as it is passed to the backends,
+// without comments and with all white space collapsed
+func normalize(instr string) string {
+	instr = strings.Split(instr, "/*")[0]
+	return strings.Join(strings.Fields(instr), " ")
+}
+
+// cacheKey returns the name of the cache entry for an instruction
+// assembled by the backend identified by id (including its version
+// and flags) for the given architecture
+func cacheKey(arch, id, instr string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", arch, id, normalize(instr))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Name and contents of the file marking a directory as a cache of
+// asm2plan9s (following the Cache Directory Tagging Specification), so
+// that clearCache never removes a directory that happens to be given
+const (
+	cacheTagName = "CACHEDIR.TAG"
+	cacheTag     = "Signature: 8a477f597d28d172789f06886806bc55\n# This file is a cache directory tag created by asm2plan9s.\n"
+)
+
+func cachePath(dir, key string) string {
+	return filepath.Join(dir, key[:2], key[2:])
+}
+
+// cacheGet returns the cached opcodes for key
+func cacheGet(dir, key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(cachePath(dir, key))
+	if err != nil {
+		return nil, false
+	}
+	opcodes, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, false
+	}
+	return opcodes, true
+}
+
+// cachePut stores the opcodes for key, failures are ignored
+// as the cache is merely an optimization
+func cachePut(dir, key string, opcodes []byte) {
+	path := cachePath(dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tag := filepath.Join(dir, cacheTagName)
+	if _, err := os.Stat(tag); os.IsNotExist(err) {
+		ioutil.WriteFile(tag, []byte(cacheTag), 0644)
+	}
+	tmpfile, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmpfile.WriteString(hex.EncodeToString(opcodes) + "\n")
+	if cerr := tmpfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return
+	}
+	if err := os.Rename(tmpfile.Name(), path); err != nil {
+		os.Remove(tmpfile.Name())
+	}
+}
+
+// clearCache removes all cached encodings, refusing to touch a directory
+// that was not created as a cache by cachePut
+func clearCache(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("no cache directory")
+	}
+	tag := filepath.Join(dir, cacheTagName)
+	if b, err := ioutil.ReadFile(tag); err != nil || string(b) != cacheTag {
+		if os.IsNotExist(err) {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				return nil // nothing cached yet
+			}
+		}
+		return fmt.Errorf("%s is not a cache directory of asm2plan9s (no %s)", dir, cacheTagName)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		// Only the shards named after the first two hex digits of the keys
+		if fi.IsDir() && len(fi.Name()) == 2 && isHex(fi.Name()) {
+			if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(tag); err != nil {
+		return err
+	}
+	os.Remove(dir) // only when empty
+	return nil
+}
+
+var (
+	versionMu sync.Mutex
+	versions  = make(map[string]string)
+)
+
+// toolVersion returns the first line printed by app --version
+func toolVersion(app string) (string, error) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	if v, ok := versions[app]; ok {
+		return v, nil
+	}
+	release := acquireProc()
+	out, err := exec.Command(app, "--version").Output()
+	release()
+	if err != nil {
+		return "", fmt.Errorf("exec error: %v", err)
+	}
+	v := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	versions[app] = v
+	return v, nil
+}
+
+// asCached assembles the instructions with backend b, only invoking it
+// for the instructions that are not in the cache
+func asCached(instructions []Instruction, arch *arch, b backend, opts *Options) error {
+	id, err := b.version(opts)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(instructions))
+	misses := make([]Instruction, 0)
+	index := make([]int, 0)
+	for i := range instructions {
+		keys[i] = cacheKey(arch.name, b.name+" "+id, instructions[i].instruction+" "+strings.Join(instructions[i].extensions, " "))
+		if opcodes, ok := cacheGet(opts.CacheDir, keys[i]); ok {
+			instructions[i].opcodes = opcodes
+			continue
+		}
+		misses = append(misses, instructions[i])
+		index = append(index, i)
+	}
+	if len(misses) == 0 {
+		return nil
+	}
+
+	if err := b.as(misses, opts); err != nil {
+		return err
+	}
+	for j, i := range index {
+		instructions[i].opcodes = misses[j].opcodes
+		cachePut(opts.CacheDir, keys[i], misses[j].opcodes)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := cacheKey("amd64", "gas /usr/bin/as GNU assembler 2.40", " VPADDQ  XMM0,XMM1,XMM8")
+	if key != cacheKey("amd64", "gas /usr/bin/as GNU assembler 2.40", "VPADDQ XMM0,XMM1,XMM8 /* X0 */") {
+		t.Errorf("expected equal keys for normalized instructions")
+	}
+	if key == cacheKey("amd64", "gas /usr/bin/as GNU assembler 2.41", " VPADDQ  XMM0,XMM1,XMM8") {
+		t.Errorf("expected different keys for different versions")
+	}
+
+	if _, ok := cacheGet(dir, key); ok {
+		t.Errorf("expected cache miss")
+	}
+	opcodes := []byte{0xc4, 0xc1, 0x71, 0xd4, 0xc0}
+	cachePut(dir, key, opcodes)
+	if result, ok := cacheGet(dir, key); !ok || !bytes.Equal(result, opcodes) {
+		t.Errorf("expected %v\ngot                     %v", opcodes, result)
+	}
+
+	if err := clearCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cacheGet(dir, key); ok {
+		t.Errorf("expected cache miss after clearing")
+	}
+}
+
+func TestClearCacheUntagged(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A directory given by mistake, eg. -cachedir=$HOME
+	for _, name := range []string{"ab", "notes.txt"} {
+		if err := os.MkdirAll(filepath.Join(dir, name+".d", name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "ab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clearCache(dir); err == nil {
+		t.Errorf("expected error for a directory without %s", cacheTagName)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected directory to be left alone\ngot                     %d entries", len(entries))
+	}
+
+	// Other files next to the cache entries are kept as well
+	cachePut(dir, cacheKey("amd64", "gas", "NOP"), []byte{0x90})
+	if err := clearCache(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range entries {
+		if fi.Name() == "ab" || fi.Name() == cacheTagName {
+			t.Errorf("expected %s to be removed", fi.Name())
+		}
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected the other entries to be kept\ngot                     %d entries", len(entries))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Instruction forms that need EVEX encoding (AVX512): ZMM registers,
+// XMM/YMM registers 16-31, opmask registers, zeroing, embedded broadcast
+// and rounding, and EVEX only mnemonics
+var regexpEVEX = regexp.MustCompile(`(?i)\bzmm\d+\b|\b[xy]mm(1[6-9]|2\d|3[01])\b|\bk[0-7]\b|\{z\}|\{1to\d+\}|\{r[nduz]-sae\}|\{sae\}|` +
+	`^\s*(k[a-z]+[bwdq]\b|vpternlog|vprol|vpror|vperm[it]2|vpmov(us|s)?(qb|qw|qd|db|dw|wb)\b|vpmov[bwdq]2m|vpmovm2|vpcompress|vpexpand|vcompress|vexpand|vpconflict|vplzcnt|` +
+	`valign[dq]|vpabsq|vp(max|min)[su]q|vpmullq|vpsraq|vscalef|vrndscale|vfixupimm|vgetexp|vgetmant|vrcp14|vrsqrt14|vpopcnt|` +
+	`vpshufbitqmb|vpmultishift|vpermb|vpermw|vpshld|vpshrd|vpcmp[a-z]*\s+k|vp?test[n]?m|vcvt[a-z]*2u|vcvtu|vdbpsadbw|vpdpbusd|vpdpwssd|vinsert[if](32x|64x)|vextract[if](32x|64x)|vshuf[if](32x|64x)|vbroadcast[if](32x|64x))`)
+
+// requiresAmd64 returns the capabilities a backend needs to encode an instruction
+func requiresAmd64(instr string) []string {
+	if regexpEVEX.MatchString(normalize(instr)) {
+		return []string{"avx512"}
+	}
+	return nil
+}
+
+var (
+	probeMu sync.Mutex
+	probed  = make(map[string]bool)
+)
+
+// capable determines whether the backend can encode the instruction. Every
+// capability is probed only once per backend by assembling the probe
+// instruction of the architecture for it.
+func (b backend) capable(arch *arch, instr string, opts *Options) bool {
+	if arch.requires == nil {
+		return true
+	}
+	id, err := b.version(opts)
+	if err != nil {
+		return false
+	}
+	for _, c := range arch.requires(instr) {
+		probe, ok := arch.probes[c]
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s\x00%s\x00%s\x00%s", arch.name, b.name, id, c)
+
+		probeMu.Lock()
+		supported, done := probed[key]
+		probeMu.Unlock()
+
+		if !done {
+			ins := []Instruction{{instruction: " " + probe}}
+			supported = b.as(ins, opts) == nil
+			probeMu.Lock()
+			probed[key] = supported
+			probeMu.Unlock()
+		}
+		if !supported {
+			return false
+		}
+	}
+	return true
+}
+
+// lineRanges formats (sorted) line numbers compactly, eg. "3, 7-9"
+func lineRanges(lines []int) string {
+	parts := make([]string, 0)
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", lines[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
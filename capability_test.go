@@ -0,0 +1,58 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRequiresAmd64(t *testing.T) {
+
+	testCases := []struct {
+		instr string
+		out   string
+	}{
+		{" VPADDQ  XMM0,XMM1,XMM8", ""},
+		{" VPADDQ  YMM0,YMM1,YMM15", ""},
+		{" VPADDQ  ZMM0,ZMM1,ZMM8", "avx512"},
+		{" VPADDQ  XMM16,XMM1,XMM8", "avx512"},
+		{" VPADDQ  XMM0 {k1}{z}, XMM1, XMM8", "avx512"},
+		{" VPADDD  YMM0, YMM1, [rax]{1to8}", "avx512"},
+		{" VPTERNLOGD XMM0, XMM1, XMM2, 0x96", "avx512"},
+		{" KMOVW   K1, EAX", "avx512"},
+		{" VPSHUFB YMM1, YMM1, YMM2", ""},
+		{" VPMOVMSKB EAX, YMM1", ""},
+		{" VPMOVZXBW YMM1, XMM2", ""},
+		{" VPMOVQB XMM1, XMM2", "avx512"},
+	}
+
+	for i, tc := range testCases {
+		result := strings.Join(requiresAmd64(tc.instr), ",")
+		if result != tc.out {
+			t.Errorf("test %d: expected %q\ngot                     %q for %s", i, tc.out, result, tc.instr)
+		}
+	}
+}
+
+func TestLineRanges(t *testing.T) {
+
+	out := "1, 3-5, 9"
+	if result := lineRanges([]int{1, 3, 4, 5, 9}); result != out {
+		t.Errorf("expected %s\ngot                     %s", out, result)
+	}
+}
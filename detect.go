@@ -0,0 +1,239 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A byte sequence as written by asm2plan9s: any combination of QUAD, LONG,
+// WORD (16-bit, or 32-bit for arm64) and BYTE values, separated by
+// semicolons and padded with spaces (plus a back slash in a #define)
+var regexpByteSequence = regexp.MustCompile(`^    ` + byteSequenceItem + `(; ` + byteSequenceItem + `)*( +\\)? +$`)
+
+// The values of a byte sequence only, see byteSequence
+var regexpSequenceValues = regexp.MustCompile(`^` + byteSequenceItem + `(; ` + byteSequenceItem + `)*$`)
+
+const byteSequenceItem = `(QUAD \$0x[0-9a-fA-F]{16}|LONG \$0x[0-9a-fA-F]{8}|WORD \$0x[0-9a-fA-F]{8}|WORD \$0x[0-9a-fA-F]{4}|BYTE \$0x[0-9a-fA-F]{2})`
+
+// Maximum length of an x86 instruction
+const maxInstructionLength = 15
+
+// imaginarySequence returns the number of opcodes of a sequence that would
+// take the space of prefix (plus a space), or 0 if there is no such sequence
+func imaginarySequence(prefix string) int {
+	if len(strings.TrimSpace(prefix)) != 0 {
+		return 0
+	}
+	for objcodes := 1; objcodes <= maxInstructionLength; objcodes++ {
+		for _, w := range sequenceWidths(objcodes) {
+			if len(prefix) == w+1 { // comment starts after a space
+				return objcodes
+			}
+		}
+	}
+	return 0
+}
+
+// sequenceWidths returns the lengths (including the indentation) of the
+// sequences for the given number of opcodes: any mix of QUADs and LONGs,
+// followed by at most one WORD and one BYTE. This covers the sequences
+// written by toPlan9s as well as those starting with LONGs only.
+func sequenceWidths(objcodes int) []int {
+	widths := make([]int, 0)
+	for qs := 0; qs*8 <= objcodes; qs++ {
+		for ls := 0; qs*8+ls*4 <= objcodes; ls++ {
+			rest := objcodes - qs*8 - ls*4
+			if rest > 3 {
+				continue
+			}
+			ws, bs := rest/2, rest%2
+			widths = append(widths, 4+qs*len("QUAD $0x0011223344556677")+ls*len("LONG $0x00112233")+ws*len("WORD $0x0011")+bs*len("BYTE $0x00")+
+				(qs+ls+ws+bs-1)*len("; "))
+		}
+	}
+	return widths
+}
+
+// Column of the // for an instruction in a #define, or aligned by asmfmt
+const commentColumn = 66
+
+// detection describes whether a line holds an instruction to assemble and
+// which rule decided, see detect
+type detection struct {
+	instruction bool
+	inDefine    bool   // instruction preceded by a #define continuation
+	prefix      string // text in front of the //, with tabs expanded
+	comment     string // text following the //, as is
+	text        string // instruction following the // (and the marker)
+	marker      string // explicit marker in front of the instruction, if any
+	note        string // trailing commentary following the instruction, if any
+	rule        string // why the line was (not) taken as an instruction
+	warning     string // set when the line almost holds an instruction
+}
+
+// detect determines whether line holds an instruction. This is the case when
+// the comment starts with the marker, follows a byte sequence (or the space
+// for one), or starts in column 66.
+func detect(line, marker string) (d detection) {
+	pos := strings.Index(line, "//")
+	if pos < 0 {
+		d.rule = "no // comment"
+		return d
+	}
+
+	d.prefix = strings.Replace(line[:pos], "\t", "    ", -1)
+	d.comment = line[pos+2:]
+	d.inDefine = strings.HasSuffix(strings.TrimSpace(d.prefix), `\`)
+	marked := marker != "" && strings.HasPrefix(d.comment, marker)
+
+	switch n := imaginarySequence(d.prefix); {
+	case marked && replaceable(d.prefix):
+		d.marker = marker
+		d.rule = "explicit //" + marker + " marker"
+	case regexpByteSequence.MatchString(d.prefix):
+		d.rule = "follows a byte sequence"
+	case n > 0:
+		d.rule = fmt.Sprintf("// in column %d follows the space for a sequence of %d bytes", len(d.prefix)+1, n)
+	case !marked && width(d.prefix) == commentColumn-1:
+		d.rule = fmt.Sprintf("// in column %d", commentColumn)
+	default:
+		d.rule, d.warning = nearMiss(d.prefix, d.comment, marker)
+		if d.warning != "" && strings.Contains(line[:pos], "\t") {
+			d.warning += " (counting a tab as 4 spaces)"
+		}
+		return d
+	}
+	d.instruction = true
+	d.text, d.note = splitNote(d.comment[len(d.marker):])
+	return d
+}
+
+// replaceable determines whether the text in front of a marked instruction
+// may be overwritten: white space, a byte sequence or a #define continuation
+func replaceable(prefix string) bool {
+	seq := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(prefix), `\`))
+	return seq == "" || regexpSequenceValues.MatchString(seq)
+}
+
+// width returns the number of columns taken by s (counting runes, not bytes)
+func width(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// Separators of the commentary that may follow an instruction, eg.
+// "// VPSHUFB YMM1, YMM1, YMM2 // rotate lanes"
+var noteSeparators = []string{"//", "/*", ";"}
+
+// splitNote splits the text of an instruction comment into the instruction
+// and the trailing commentary (including the white space in front of it)
+func splitNote(text string) (instruction, note string) {
+	end := len(text)
+	for _, sep := range noteSeparators {
+		if i := strings.Index(text, sep); i >= 0 && i < end {
+			end = i
+		}
+	}
+	instruction = strings.TrimRightFunc(text[:end], unicode.IsSpace)
+	return instruction, text[len(instruction):]
+}
+
+// nearMiss explains why a comment following prefix is not an instruction,
+// with a warning when it only just misses one of the rules
+func nearMiss(prefix, text, marker string) (rule, warning string) {
+	col := width(prefix) + 1
+	trimmed := strings.TrimSpace(prefix)
+
+	switch {
+	case trimmed == "" || trimmed == `\`:
+		if trimmed == "" {
+			rule = fmt.Sprintf("// in column %d does not follow the space for a sequence of 1 to %d bytes", col, maxInstructionLength)
+		} else {
+			rule = fmt.Sprintf("// in column %d in a #define is not in column %d", col, commentColumn)
+		}
+		for _, want := range instructionColumns(trimmed != "") {
+			if col == want-1 || col == want+1 {
+				warning = fmt.Sprintf("// in column %d is one column off from column %d", col, want)
+			}
+		}
+	case regexpByteSequence.MatchString("    " + strings.TrimLeft(prefix, " ")):
+		rule = "text in front of // is not a byte sequence"
+		warning = "byte sequence must be indented by 4 spaces (or a tab)"
+	case regexpByteSequence.MatchString(prefix + " "):
+		rule = "text in front of // is not a byte sequence"
+		warning = "byte sequence must be followed by a space"
+	case regexpByteSequenceStart.MatchString(trimmed):
+		rule = "text in front of // is not a byte sequence"
+		warning = "looks like a byte sequence, but not as written by asm2plan9s (check the number of hex digits and the '; ' separators)"
+	default:
+		rule = "text in front of // is not a byte sequence"
+	}
+
+	if warning == "" && marker != "" && !strings.HasPrefix(text, marker) && strings.HasPrefix(strings.TrimSpace(text), marker) {
+		warning = fmt.Sprintf("marker must follow the // directly, as in //%s", marker)
+	}
+	if warning == "" && marker != "" && strings.HasPrefix(text, marker) {
+		warning = fmt.Sprintf("code in front of //%s is never overwritten, put the instruction on a line of its own", marker)
+	}
+	return rule, warning
+}
+
+// Start of a (hand written) byte sequence
+var regexpByteSequenceStart = regexp.MustCompile(`^(QUAD|LONG|WORD|BYTE)\s+\$`)
+
+// instructionColumns returns the columns of the // that make a comment
+// following whitespace an instruction
+func instructionColumns(inDefine bool) []int {
+	if inDefine {
+		return []int{commentColumn}
+	}
+	cols := []int{commentColumn}
+	for objcodes := 1; objcodes <= maxInstructionLength; objcodes++ {
+		for _, w := range sequenceWidths(objcodes) {
+			cols = append(cols, w+2)
+		}
+	}
+	return cols
+}
+
+// explain reports for every line with a comment whether it is taken as an
+// instruction and why (or only the warnings when all is false)
+func explain(w io.Writer, filename string, lines []string, marker string, all bool) {
+	for lineno, line := range lines {
+		d := detect(line, marker)
+		if d.rule == "no // comment" {
+			continue
+		}
+		if all {
+			kind := "plain text"
+			if d.instruction && d.inDefine {
+				kind = "instruction in #define"
+			} else if d.instruction {
+				kind = "instruction"
+			}
+			fmt.Fprintf(w, "%s:%d: %s (%s)\n", filename, lineno+1, kind, d.rule)
+		}
+		if d.warning != "" {
+			fmt.Fprintf(w, "%s:%d: warning: %s\n", filename, lineno+1, d.warning)
+		}
+	}
+}
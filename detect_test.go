@@ -0,0 +1,127 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+
+	pad := func(n int) string { return strings.Repeat(" ", n) }
+
+	testCases := []struct {
+		line        string
+		instruction bool
+		inDefine    bool
+		rule        string
+		warning     string
+	}{
+		{"    MOVQ AX, BX", false, false, "no // comment", ""},
+		{"    // comment", false, false, "// in column 5 does not follow", ""},
+		{"    MOVQ AX, BX // comment", false, false, "not a byte sequence", ""},
+		{"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8", true, false, "follows a byte sequence", ""},
+		{`    LONG $0xd471c1c4; BYTE $0xc0 \ // VPADDQ  XMM0,XMM1,XMM8`, true, true, "follows a byte sequence", ""},
+		{pad(33) + "// VPADDQ  XMM0,XMM1,XMM8", true, false, "sequence of 5 bytes", ""},
+		{"\t" + pad(29) + "// VPADDQ  XMM0,XMM1,XMM8", true, false, "sequence of 5 bytes", ""},
+		{pad(65) + "// VPADDQ  XMM0,XMM1,XMM8", true, false, "// in column 66", ""},
+		{pad(39) + "// VPCMPEQB YMM1, YMM2, [RAX+0x1000]", true, false, "sequence of 8 bytes", ""}, // LONG; LONG
+		{pad(29) + "// SHL RAX, 1", true, false, "sequence of 3 bytes", ""},                        // WORD; BYTE
+		{pad(38) + "// VPCMPEQB YMM1, YMM2, [RAX+0x1000]", false, false, "column 39", "one column off from column 40"},
+		{pad(63) + `\ // VPADDQ  XMM0,XMM1,XMM8`, true, true, "// in column 66", ""},
+		{"    //asm: VPADDQ  XMM0,XMM1,XMM8", true, false, "explicit //asm: marker", ""},
+		{pad(64) + "// VPADDQ  XMM0,XMM1,XMM8", false, false, "column 65", "one column off from column 66"},
+		{"\t" + pad(60) + "// VPADDQ  XMM0,XMM1,XMM8", false, false, "column 65", "(counting a tab as 4 spaces)"},
+		{pad(62) + `\ // VPADDQ  XMM0,XMM1,XMM8`, false, true, "in a #define", "one column off from column 66"},
+		{"     LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8", false, false, "not a byte sequence", "indented by 4 spaces"},
+		{"    LONG $0xd471c1c4; BYTE $0xc0// VPADDQ  XMM0,XMM1,XMM8", false, false, "not a byte sequence", "followed by a space"},
+		{"    LONG $0xd471c1c; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8", false, false, "not a byte sequence", "hex digits"},
+		{"    // asm: VPADDQ  XMM0,XMM1,XMM8", false, false, "column 5", "marker must follow the // directly"},
+		{"\tMOVQ AX, BX //asm: VPADDQ  XMM0,XMM1,XMM8", false, false, "not a byte sequence", "code in front of //asm: is never overwritten"},
+		{"    MOVQ AX, BX" + pad(50) + "//asm: VPADDQ  XMM0,XMM1,XMM8", false, false, "not a byte sequence", "code in front of //asm:"},
+		{"    LONG $0x00000000; BYTE $0x00 //asm: VPADDQ  XMM0,XMM1,XMM8", true, false, "explicit //asm: marker", ""},
+		{`    BYTE $0x00 \ //asm: VPADDQ  XMM0,XMM1,XMM8`, true, true, "explicit //asm: marker", ""},
+		{"    BYTE $0x90 // NOP // again", true, false, "follows a byte sequence", ""},
+	}
+
+	for _, tc := range testCases {
+		d := detect(tc.line, exampleMarker)
+		if d.instruction != tc.instruction || d.inDefine != tc.inDefine {
+			t.Errorf("%q: expected instruction %v (in define %v)\ngot                     %v (%v): %s", tc.line, tc.instruction, tc.inDefine, d.instruction, d.inDefine, d.rule)
+		}
+		if !strings.Contains(d.rule, tc.rule) {
+			t.Errorf("%q: expected rule %s\ngot                     %s", tc.line, tc.rule, d.rule)
+		}
+		if (tc.warning == "") != (d.warning == "") || !strings.Contains(d.warning, tc.warning) {
+			t.Errorf("%q: expected warning %s\ngot                     %s", tc.line, tc.warning, d.warning)
+		}
+	}
+}
+
+func TestSplitNote(t *testing.T) {
+
+	testCases := []struct {
+		line        string
+		instruction string
+		note        string
+	}{
+		{"    LONG $0x00000000; BYTE $0x00 // VPSHUFB YMM1, YMM1, YMM2", " VPSHUFB YMM1, YMM1, YMM2", ""},
+		{"    LONG $0x00000000; BYTE $0x00 // VPSHUFB YMM1, YMM1, YMM2 // rotate lanes", " VPSHUFB YMM1, YMM1, YMM2", " // rotate lanes"},
+		{"    LONG $0x00000000; BYTE $0x00 // VPSHUFB YMM1, YMM1, YMM2\t/* rotate // lanes */", " VPSHUFB YMM1, YMM1, YMM2", "\t/* rotate // lanes */"},
+		{"    LONG $0x00000000; BYTE $0x00 // VPSHUFB YMM1, YMM1, YMM2;rotate lanes", " VPSHUFB YMM1, YMM1, YMM2", ";rotate lanes"},
+		{"    //asm: VPSHUFB YMM1, YMM1, YMM2 ; rotate /* lanes */", " VPSHUFB YMM1, YMM1, YMM2", " ; rotate /* lanes */"},
+	}
+
+	for _, tc := range testCases {
+		d := detect(tc.line, exampleMarker)
+		if !d.instruction {
+			t.Errorf("%q: expected instruction\ngot                     %s", tc.line, d.rule)
+			continue
+		}
+		if d.text != tc.instruction || d.note != tc.note {
+			t.Errorf("%q: expected %q and %q\ngot                     %q and %q", tc.line, tc.instruction, tc.note, d.text, d.note)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+
+	lines := []string{
+		"    MOVQ AX, BX",
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8",
+		`    LONG $0xd471c1c4; BYTE $0xc0 \ // VPADDQ  XMM0,XMM1,XMM8`,
+		"    LONG $0xd471c1c4; BYTE $0xc0// VPADDQ  XMM0,XMM1,XMM8",
+	}
+	out := "test.s:2: instruction (follows a byte sequence)\n" +
+		"test.s:3: instruction in #define (follows a byte sequence)\n" +
+		"test.s:4: plain text (text in front of // is not a byte sequence)\n" +
+		"test.s:4: warning: byte sequence must be followed by a space\n"
+
+	var buf bytes.Buffer
+	explain(&buf, "test.s", lines, exampleMarker, true)
+	if buf.String() != out {
+		t.Errorf("expected %s\ngot                     %s", out, buf.String())
+	}
+
+	buf.Reset()
+	explain(&buf, "test.s", lines, exampleMarker, false)
+	if want := "test.s:4: warning: byte sequence must be followed by a space\n"; buf.String() != want {
+		t.Errorf("expected %s\ngot                     %s", want, buf.String())
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Number of unchanged lines shown around every change
+const diffContext = 3
+
+// Upper bound on the number of edits searched for by diffLines, beyond
+// this the remaining lines are reported as replaced wholesale
+const maxDiffEdits = 4096
+
+type edit struct {
+	op   byte // ' ' (equal), '-' (delete) or '+' (insert)
+	a, b int  // index into the old and new lines
+}
+
+// diffLines computes the shortest edit script turning a into b
+// using the algorithm by Myers.
+func diffLines(a, b []string) []edit {
+	edits := make([]edit, 0, len(a))
+
+	// Strip common prefix and suffix to keep the search small
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		edits = append(edits, edit{' ', pre, pre})
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	edits = append(edits, myers(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+
+	for i := suf; i > 0; i-- {
+		edits = append(edits, edit{' ', len(a) - i, len(b) - i})
+	}
+	return edits
+}
+
+func myers(a, b []string, offset int) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > 2*maxDiffEdits {
+		max = 2 * maxDiffEdits
+	}
+	v := make([]int, 2*max+3)
+	off := max + 1
+	trace := make([][]int, 0, 16)
+
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		window := make([]int, 2*d+1)
+		copy(window, v[off-d:off+d+1])
+		trace = append(trace, window)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		// Too many differences, replace everything
+		edits := make([]edit, 0, n+m)
+		for i := range a {
+			edits = append(edits, edit{'-', offset + i, offset})
+		}
+		for j := range b {
+			edits = append(edits, edit{'+', offset + n, offset + j})
+		}
+		return edits
+	}
+
+	// Walk back through the trace to recover the edits
+	reversed := make([]edit, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		window := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && window[k-1+d] < window[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = window[prevK+d]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			reversed = append(reversed, edit{' ', offset + x, offset + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				reversed = append(reversed, edit{'+', offset + x, offset + y})
+			} else {
+				x--
+				reversed = append(reversed, edit{'-', offset + x, offset + y})
+			}
+		}
+	}
+
+	edits := make([]edit, len(reversed))
+	for i := range reversed {
+		edits[i] = reversed[len(reversed)-1-i]
+	}
+	return edits
+}
+
+// unifiedDiff returns the differences between the old and new lines
+// in unified format, or an empty string when they are identical.
+func unifiedDiff(oldName, newName string, a, b []string) string {
+	edits := diffLines(a, b)
+
+	var buf bytes.Buffer
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk as long as changes are close enough together
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits) && j < end+2*diffContext+1; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		end += diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+
+		oldCount, newCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(edits[start].a, oldCount), hunkRange(edits[start].b, newCount))
+		for _, e := range edits[start:end] {
+			switch e.op {
+			case ' ':
+				fmt.Fprintf(&buf, " %s\n", a[e.a])
+			case '-':
+				fmt.Fprintf(&buf, "-%s\n", a[e.a])
+			case '+':
+				fmt.Fprintf(&buf, "+%s\n", b[e.b])
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
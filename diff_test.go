@@ -0,0 +1,75 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+
+	a := strings.Split("a b c d e f g", " ")
+	b := strings.Split("a c d x e g h", " ")
+
+	var gotA, gotB []string
+	for _, e := range diffLines(a, b) {
+		if e.op != '+' {
+			gotA = append(gotA, a[e.a])
+		}
+		if e.op != '-' {
+			gotB = append(gotB, b[e.b])
+		}
+	}
+	if strings.Join(gotA, " ") != strings.Join(a, " ") || strings.Join(gotB, " ") != strings.Join(b, " ") {
+		t.Errorf("edits do not reproduce input: %v %v", gotA, gotB)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+
+	a := []string{
+		"TEXT ·foo(SB), 7, $0",
+		"    MOVQ AX, BX",
+		"    LONG $0x003377bb; BYTE $0xff // VPADDQ  XMM0,XMM1,XMM8",
+		"    RET",
+	}
+	b := []string{
+		"TEXT ·foo(SB), 7, $0",
+		"    MOVQ AX, BX",
+		"    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8",
+		"    RET",
+	}
+	out := `--- foo_amd64.s.orig
++++ foo_amd64.s
+@@ -1,4 +1,4 @@
+ TEXT ·foo(SB), 7, $0
+     MOVQ AX, BX
+-    LONG $0x003377bb; BYTE $0xff // VPADDQ  XMM0,XMM1,XMM8
++    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8
+     RET
+`
+
+	result := unifiedDiff("foo_amd64.s.orig", "foo_amd64.s", a, b)
+	if result != out {
+		t.Errorf("expected %s\ngot                     %s", out, result)
+	}
+
+	if result := unifiedDiff("a", "b", a, a); result != "" {
+		t.Errorf("expected no diff\ngot                     %s", result)
+	}
+}
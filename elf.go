@@ -0,0 +1,83 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Label placed by the GAS backends in front of every instruction, and after
+// the last one, to find the code of every instruction in the object file.
+// Note that labels starting with .L would not make it into the symbol table.
+const gasLabel = "asm2plan9s_%d"
+
+// gasLabelLine returns the source line defining the label in front of instruction i
+func gasLabelLine(i int) string {
+	return fmt.Sprintf(gasLabel+":\n", i)
+}
+
+// fromObject attaches the code in the .text section of an ELF object to
+// the instructions, splitting it at the labels placed in front of every
+// instruction. The instructions are left alone when this fails (eg. for
+// objects in another format), so that the listing can be used instead.
+func fromObject(objFile string, instructions []Instruction) error {
+	f, err := elf.Open(objFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	text := f.Section(".text")
+	if text == nil {
+		return errors.New("no .text section in object file")
+	}
+	data, err := text.Data()
+	if err != nil {
+		return err
+	}
+	symbols, err := f.Symbols()
+	if err != nil {
+		return err
+	}
+
+	addrs := make(map[string]uint64)
+	for _, s := range symbols {
+		if !strings.HasPrefix(s.Name, "asm2plan9s_") || int(s.Section) >= len(f.Sections) || f.Sections[s.Section] != text {
+			continue
+		}
+		addrs[s.Name] = s.Value
+	}
+
+	opcodes := make([][]byte, len(instructions))
+	for i := range instructions {
+		start, ok := addrs[fmt.Sprintf(gasLabel, i)]
+		end, ok2 := addrs[fmt.Sprintf(gasLabel, i+1)]
+		if !ok || !ok2 || start > end || end > uint64(len(data)) {
+			return fmt.Errorf("no code for '%s' in .text section of object file", strings.TrimSpace(instructions[i].instruction))
+		}
+		opcodes[i] = data[start:end]
+	}
+
+	for i := range instructions {
+		instructions[i].opcodes = make([]byte, len(opcodes[i]))
+		copy(instructions[i].opcodes, opcodes[i])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFromObject(t *testing.T) {
+
+	if runtime.GOARCH != "amd64" {
+		t.Skip("needs a native GNU assembler for amd64")
+	}
+	if _, err := exec.LookPath("as"); err != nil {
+		t.Skip("GNU assembler not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ ZMM0, ZMM1, ZMM2"},
+		{instruction: " loop:"},
+		{instruction: " .byte 1, 2, 3"},
+		{instruction: " MOV RAX, RBX"},
+	}
+	src := ".intel_syntax noprefix\n"
+	for i, ins := range instructions {
+		src += gasLabelLine(i) + ins.instruction + "\n"
+	}
+	src += gasLabelLine(len(instructions))
+
+	asmFile, objFile := filepath.Join(dir, "test.s"), filepath.Join(dir, "test.o")
+	if err := ioutil.WriteFile(asmFile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("as", "-o", objFile, asmFile).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	if err := fromObject(objFile, instructions); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	out := [][]byte{{0x62, 0xf1, 0xf5, 0x48, 0xd4, 0xc2}, {}, {1, 2, 3}, {0x48, 0x89, 0xd8}}
+	for i, ins := range instructions {
+		if ins.opcodes == nil || !bytes.Equal(ins.opcodes, out[i]) {
+			t.Errorf("expected %x\ngot                     %x", out[i], ins.opcodes)
+		}
+	}
+
+	// Anything but an ELF object leaves the instructions alone
+	instructions[0].opcodes = nil
+	if err := fromObject(asmFile, instructions); err == nil || instructions[0].opcodes != nil {
+		t.Errorf("expected an error for a file that is not an object")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AssembleError reports an instruction that the assembler rejected, or
+// output of the assembler that could not be made sense of. Line, Col and
+// Instruction are only set when the error can be tied to an instruction.
+type AssembleError struct {
+	File        string // name of the source file
+	Line        int    // line of the instruction (1-based)
+	Col         int    // column of the instruction text (1-based)
+	Instruction string
+	Backend     string // name of the backend that reported the error
+	Msg         string // message of the assembler
+	Stderr      string // raw output of the assembler
+	Err         error  // underlying cause
+}
+
+// newAssembleError returns an error for ins in filename with the message of the assembler
+func newAssembleError(ins *Instruction, filename, msg string) *AssembleError {
+	return &AssembleError{
+		File:        filename,
+		Line:        ins.lineno + 1,
+		Col:         ins.col,
+		Instruction: strings.TrimSpace(ins.instruction),
+		Msg:         msg,
+	}
+}
+
+// Error formats the error like the go tools do, ie. "file.s:LINE:COL: message"
+func (e *AssembleError) Error() string {
+	parts := make([]string, 0, 4)
+	pos := e.File
+	if e.Line > 0 {
+		pos = strings.TrimPrefix(fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Col), ":")
+	}
+	if pos != "" {
+		parts = append(parts, pos)
+	}
+	if e.Backend != "" {
+		parts = append(parts, e.Backend)
+	}
+	if e.Instruction != "" {
+		parts = append(parts, "'"+e.Instruction+"'")
+	}
+	msg := e.Msg
+	if e.Err != nil {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += e.Err.Error()
+	}
+	return strings.Join(append(parts, msg), ": ")
+}
+
+// Unwrap returns the underlying cause
+func (e *AssembleError) Unwrap() error { return e.Err }
+
+// AssembleErrors lists all instructions of a file that failed to assemble,
+// with an error for every backend that rejected the instruction
+type AssembleErrors []*AssembleError
+
+func (e AssembleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// instructions returns the number of instructions that failed, as every
+// backend that rejected an instruction reports its own error
+func (e AssembleErrors) instructions() int {
+	lines := make(map[int]bool)
+	for i := range e {
+		lines[e[i].Line] = true
+	}
+	return len(lines)
+}
+
+// sort orders the errors by their position in the file
+func (e AssembleErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		return e[i].Line < e[j].Line
+	})
+}
+
+// diagnostics describes how an assembler reports errors and warnings,
+// ie. as "file.asm:LINE: Error: message"
+type diagnostics struct {
+	name    string // name of the assembler for errors not tied to an instruction
+	error   string // prefix of error messages
+	warning string // prefix of warnings
+}
+
+var (
+	gasDiagnostics  = diagnostics{name: "GAS", error: "Error: ", warning: "Warning:"}
+	yasmDiagnostics = diagnostics{name: "YASM", error: "error: ", warning: "warning:"}
+)
+
+// errors converts the error messages of the assembler into an error per
+// instruction, using index to map line numbers back to instructions
+// and filename to name the source file
+func (d diagnostics) errors(output, asmFile string, index func(line int) int, instructions []Instruction, filename string) error {
+	errs := make(AssembleErrors, 0)
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, asmFile+":") {
+			continue
+		}
+		fields := strings.SplitN(line[len(asmFile)+1:], ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		l, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		msg := strings.TrimSpace(fields[1])
+		if strings.HasPrefix(msg, d.warning) {
+			continue
+		}
+		if i := index(l); i >= 0 {
+			e := newAssembleError(&instructions[i], filename, strings.TrimPrefix(msg, d.error))
+			e.Stderr = output
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == 0 {
+		return errBatch(d.name + " error: " + strings.TrimSpace(output))
+	}
+	return errs
+}
@@ -0,0 +1,35 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+)
+
+// gasIdentity identifies the GNU assembler for arch by its path,
+// its version and the flags it is invoked with
+func gasIdentity(arch string, opts *Options, flags ...string) (string, error) {
+	app, err := gasCommand(arch, opts.As)
+	if err != nil {
+		return "", err
+	}
+	v, err := toolVersion(app)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{app, v}, flags...), " "), nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//
+// A GAS listing (as -al) looks like this:
+//
+//	GAS LISTING example.s 			page 1
+//
+//
+//	   1              	.intel_syntax noprefix
+//	   2 0000 62F1F548 	VPANDQ   ZMM0, ZMM1, ZMM2
+//	   2      DBC2
+//	   3              	loop:
+//	...
+//	^LGAS LISTING example.s 			page 2
+//
+//
+//	  57 00f0 48B88877 	MOVABS RAX, 0x1122334455667788
+//	  57      66554433
+//	  57      2211
+//
+// Every page starts with a header (preceded by a form feed from page 2 on),
+// unless the listing is made with -aln. Each line starts with the number of
+// the source line, right aligned to at least four digits. It is followed by
+// a single space and the address for the first line of code generated for a
+// source line, or by more spaces for the lines continuing it. Next are up to
+// four bytes of code in hex and a tab in front of the source line. The
+// address grows beyond four hex digits for large files, and lines can end
+// in CRLF when the listing passed through Windows tools.
+//
+
+// Passed to GAS so that the code of long directives is not cut short
+// in the listing (by default only the first 20 bytes are listed)
+const listingContLines = "--listing-cont-lines=100000"
+
+// listingError reports a line of a GAS listing that could not be parsed
+func listingError(line string, err error) *AssembleError {
+	return &AssembleError{Msg: fmt.Sprintf("malformed listing line %q", strings.TrimSpace(line)), Err: err}
+}
+
+// toPlan9sGas returns the opcodes in a GAS listing keyed by the number of
+// the source line they were generated for, see parseListing
+func toPlan9sGas(listFile string) (map[int][]byte, error) {
+	file, err := os.Open(listFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseListing(file)
+}
+
+// parseListing returns the opcodes in a GAS listing keyed by the number of
+// the source line they were generated for. A line can produce any number
+// of opcode groups (eg. a macro expanding to several instructions), these
+// are concatenated in order. Lines without opcodes (labels, most directives)
+// have no entry.
+func parseListing(r io.Reader) (map[int][]byte, error) {
+
+	opcodes := make(map[int][]byte)
+
+	// With -alm macro expansions are listed in addition to the line invoking
+	// the macro, so keep track of the address following the code of every
+	// line to skip groups of opcodes that were seen before
+	next := make(map[int]uint64)
+	skip := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		line = strings.TrimLeft(line, "\f")
+
+		// Drop the source line, only the columns in front of it matter
+		cols := line
+		if i := strings.IndexByte(cols, '\t'); i >= 0 {
+			cols = cols[:i]
+		}
+
+		// Page headers, blank lines and the symbol table do not start with a line number
+		rest := strings.TrimLeft(cols, " ")
+		digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+		if digits == 0 || (digits < len(rest) && rest[digits] != ' ') {
+			continue
+		}
+		l, err := strconv.Atoi(rest[:digits])
+		if err != nil {
+			return nil, listingError(line, err)
+		}
+		rest = rest[digits:]
+
+		fields := strings.Fields(rest)
+		if !strings.HasPrefix(rest, "  ") && len(fields) > 0 {
+			// The address in front of the first line of code
+			addr, err := strconv.ParseUint(fields[0], 16, 64)
+			if err != nil {
+				return nil, listingError(line, fmt.Errorf("bad address %q", fields[0]))
+			}
+			end, seen := next[l]
+			skip = seen && addr < end
+			next[l] = addr
+			fields = fields[1:]
+		}
+		if len(fields) == 0 || !isHex(fields[0]) {
+			continue // no code for this line
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, listingError(line, err)
+		}
+		if skip {
+			continue
+		}
+		opcodes[l] = append(opcodes[l], b...)
+		next[l] += uint64(len(b))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return opcodes, nil
+}
+
+// isHex determines whether s consists of hexadecimal digits only
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// labelledIndex maps the line numbers of a source file holding n
+// instructions, each preceded by its label, back to the instructions.
+// Instruction i is on line first+2i.
+func labelledIndex(first, n int) func(line int) int {
+	return func(line int) int {
+		if line < first || (line-first)%2 != 0 || (line-first)/2 >= n {
+			return -1
+		}
+		return (line - first) / 2
+	}
+}
+
+// fromListing attaches the opcodes of a GAS listing to the instructions,
+// using index to map line numbers back to instructions. Instructions that
+// do not produce any opcodes (eg. a label) are given an empty encoding.
+func fromListing(opcodes map[int][]byte, index func(line int) int, instructions []Instruction) error {
+	for i := range instructions {
+		instructions[i].opcodes = []byte{}
+	}
+	for l, op := range opcodes {
+		i := index(l)
+		if i < 0 {
+			return &AssembleError{Msg: fmt.Sprintf("opcodes %x on line %d of the listing do not belong to an instruction", op, l)}
+		}
+		instructions[i].opcodes = make([]byte, len(op))
+		copy(instructions[i].opcodes, op)
+	}
+	return nil
+}
+
+// fromGas attaches the encodings that GAS produced to the instructions,
+// taking them from the object file or, when that is not an ELF object,
+// from the listing. Errors carry the output of the assembler.
+func fromGas(objFile, lisFile string, index func(line int) int, instructions []Instruction, output []byte) error {
+	if err := fromObject(objFile, instructions); err == nil {
+		return nil
+	}
+
+	// Not an ELF object, fall back to the listing
+	opcodes, err := toPlan9sGas(lisFile)
+	if err == nil {
+		err = fromListing(opcodes, index, instructions)
+	}
+	if e, ok := err.(*AssembleError); ok {
+		e.Stderr = string(output)
+	}
+	return err
+}
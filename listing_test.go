@@ -0,0 +1,216 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The corpus in testdata was made with GNU as 2.x from a generated file of
+// 6000 random instructions, labels and directives (addresses go beyond 0xffff):
+//
+//	as --listing-cont-lines=100000 -o listing_amd64.o -al=listing_amd64.lis listing_amd64.s
+//	as --listing-cont-lines=100000 -o /dev/null -aln=listing_amd64_aln.lis short.s
+//
+// where short.s holds the first 1800 lines of listing_amd64.s. The golden
+// file lists the code of every source line as found in the .text section
+// of listing_amd64.o.
+
+func readGzip(t *testing.T, name string) []byte {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readGolden(t *testing.T, name string) map[int][]byte {
+	golden := make(map[int][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(readGzip(t, name)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		l, err := strconv.Atoi(fields[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden[l], err = hex.DecodeString(fields[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return golden
+}
+
+func TestParseListingCorpus(t *testing.T) {
+
+	golden := readGolden(t, "testdata/listing_amd64.golden.gz")
+	listing := readGzip(t, "testdata/listing_amd64.lis.gz")
+
+	testCases := []struct {
+		name    string
+		listing []byte
+		lines   int // number of source lines covered
+	}{
+		{"-al", listing, 6025},
+		{"-al with CRLF", bytes.Replace(listing, []byte("\n"), []byte("\r\n"), -1), 6025},
+		{"-aln", readGzip(t, "testdata/listing_amd64_aln.lis.gz"), 1800},
+	}
+
+	for _, tc := range testCases {
+		if n := bytes.Count(tc.listing, []byte("\n")); n < 5000 {
+			t.Fatalf("%s: expected a listing of thousands of lines, got %d lines", tc.name, n)
+		}
+		opcodes, err := parseListing(bytes.NewReader(tc.listing))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		for l, want := range golden {
+			if l > tc.lines {
+				continue
+			}
+			if got := opcodes[l]; !bytes.Equal(got, want) {
+				t.Errorf("%s: line %d: expected %x\ngot                     %x", tc.name, l, want, got)
+			}
+		}
+		for l := range opcodes {
+			if _, ok := golden[l]; !ok {
+				t.Errorf("%s: line %d: unexpected opcodes %x", tc.name, l, opcodes[l])
+			}
+		}
+	}
+}
+
+func TestParseListing(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		listing string
+		want    map[int][]byte
+	}{
+		{"page header",
+			"  57 00f0 488D84CB \tlea rax, [rbx+rcx*8+0x12345678]\n" +
+				"\fGAS LISTING example.s \t\t\tpage 2\n\n\n" +
+				"  57      78563412 \n" +
+				"  58 00f8 90       \tnop\n",
+			map[int][]byte{57: {0x48, 0x8d, 0x84, 0xcb, 0x78, 0x56, 0x34, 0x12}, 58: {0x90}}},
+		{"wide line numbers and addresses",
+			" 99999 f4222 48B88877 \tmovabs rax, 0x1122334455667788\n" +
+				" 99999      66554433 \n" +
+				" 99999      2211\n" +
+				" 100000 10c8d6 90       \tnop\n",
+			map[int][]byte{99999: {0x48, 0xb8, 0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11}, 100000: {0x90}}},
+		{"no code",
+			"   6              \tadd eax, 1\n" +
+				"   7              \tloop:\n" +
+				"   8 0004         \t.org 4\n",
+			map[int][]byte{}},
+		{"macro expansion (-alm)",
+			"   7              \t.rept 3\n" +
+				"   8              \tnop\n" +
+				"   9 0004 90       \t.endr\n" +
+				"   9 0004 90       \t> nop\n" +
+				"   9 0005 90       \t> nop\n" +
+				"   9 0006 90       \t> nop\n",
+			map[int][]byte{9: {0x90, 0x90, 0x90}}},
+		{"symbol table",
+			"   2 0000 90       \tnop\n" +
+				"DEFINED SYMBOLS\n" +
+				"           example.s:6      .text:0000000000000000 loop\n" +
+				"NO UNDEFINED SYMBOLS\n",
+			map[int][]byte{2: {0x90}}},
+	}
+
+	for _, tc := range testCases {
+		opcodes, err := parseListing(strings.NewReader(tc.listing))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if len(opcodes) != len(tc.want) {
+			t.Errorf("%s: expected %v\ngot                     %v", tc.name, tc.want, opcodes)
+			continue
+		}
+		for l, want := range tc.want {
+			if !bytes.Equal(opcodes[l], want) {
+				t.Errorf("%s: line %d: expected %x\ngot                     %x", tc.name, l, want, opcodes[l])
+			}
+		}
+	}
+}
+
+func TestFromGas(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Not an ELF object, so the listing is used
+	objFile, lisFile := filepath.Join(dir, "a.obj"), filepath.Join(dir, "a.lis")
+	if err := ioutil.WriteFile(objFile, []byte("not an object"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		listing string
+		want    []byte
+		err     bool
+	}{
+		{"   2              \tasm2plan9s_0:\n   3 0000 90       \tnop\n", []byte{0x90}, false},
+		{"   3 0000 90       \tnop\n   4 0001 90       \tnop\n", nil, true},
+		{"   3 zzzz 90       \tnop\n", nil, true},
+	}
+
+	for i, tc := range testCases {
+		if err := ioutil.WriteFile(lisFile, []byte(tc.listing), 0644); err != nil {
+			t.Fatal(err)
+		}
+		instructions := []Instruction{{instruction: " nop"}}
+		err := fromGas(objFile, lisFile, labelledIndex(3, 1), instructions, []byte("warnings"))
+		if tc.err {
+			// Errors hold the output of the assembler like any other error
+			if e, ok := err.(*AssembleError); !ok || e.Stderr != "warnings" {
+				t.Errorf("test %d: expected error with the output of the assembler, got %#v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		} else if !bytes.Equal(instructions[0].opcodes, tc.want) {
+			t.Errorf("test %d: expected %x\ngot                     %x", i, tc.want, instructions[0].opcodes)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// A lockfile records the encodings of all instructions in a file so that
+// they can be verified without an assembler being installed, eg. for
+// highwayhash_arm64.s in highwayhash_arm64.s.lock:
+//
+// # asm2plan9s lockfile, regenerate with asm2plan9s -lock
+// arch arm64
+// backend gas /usr/bin/aarch64-linux-gnu-as GNU assembler (GNU Binutils) 2.40 -march=armv8-a+crypto
+// 4cdf2870 gas ld1 {v16.4s-v19.4s}, [x3], #64
+//
+
+// Suffix of the lockfile kept next to every source file
+const lockSuffix = ".lock"
+
+const lockHeader = "# asm2plan9s lockfile, regenerate with asm2plan9s -lock"
+
+// lockfile holds the encodings recorded for a single file
+type lockfile struct {
+	arch     string
+	backends map[string]string // identity of every backend used
+	opcodes  map[string][]byte // opcodes per normalized instruction
+}
+
+// writeLockfile records the encodings of the assembled instructions
+func writeLockfile(path string, instructions []Instruction, arch *arch, opts *Options) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, lockHeader)
+	fmt.Fprintf(w, "arch %s\n", arch.name)
+
+	seen := make(map[string]bool)
+	for _, ins := range instructions {
+		if seen[ins.backend] {
+			continue
+		}
+		seen[ins.backend] = true
+		id := "unknown"
+		for _, b := range arch.backends {
+			if b.name == ins.backend {
+				if v, err := b.version(opts); err == nil {
+					id = v
+				}
+			}
+		}
+		fmt.Fprintf(w, "backend %s %s\n", ins.backend, id)
+	}
+
+	seen = make(map[string]bool)
+	for _, ins := range instructions {
+		instr := normalize(ins.instruction)
+		if seen[instr] {
+			continue
+		}
+		seen[instr] = true
+		enc := hex.EncodeToString(ins.opcodes)
+		if enc == "" {
+			enc = "-"
+		}
+		fmt.Fprintf(w, "%s %s %s\n", enc, ins.backend, instr)
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// readLockfile reads the encodings recorded in a lockfile
+func readLockfile(path string, in io.Reader) (*lockfile, error) {
+	lines, _, err := readLines(path, in)
+	if err != nil {
+		return nil, err
+	}
+
+	lf := &lockfile{backends: make(map[string]string), opcodes: make(map[string][]byte)}
+	for n, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: malformed line", path, n+1)
+		}
+		switch fields[0] {
+		case "arch":
+			lf.arch = fields[1]
+		case "backend":
+			lf.backends[fields[1]] = strings.Join(fields[2:], " ")
+		default:
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("%s:%d: malformed line", path, n+1)
+			}
+			var opcodes []byte
+			if fields[0] != "-" {
+				opcodes, err = hex.DecodeString(fields[0])
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+				}
+			}
+			lf.opcodes[fields[2]] = opcodes
+		}
+	}
+	return lf, nil
+}
+
+// fromLockfile fills in the opcodes of the instructions from the lockfile
+// instead of invoking an assembler
+func fromLockfile(path string, instructions []Instruction, arch *arch) error {
+	lf, err := readLockfile(path, nil)
+	if err != nil {
+		return err
+	}
+	if lf.arch != arch.name {
+		return fmt.Errorf("%s: recorded for %s instead of %s", path, lf.arch, arch.name)
+	}
+
+	missing := make([]string, 0)
+	for i := range instructions {
+		ins := &instructions[i]
+		opcodes, ok := lf.opcodes[normalize(ins.instruction)]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("line %d: '%s' not in %s", ins.lineno+1, strings.TrimSpace(ins.instruction), path))
+			continue
+		}
+		ins.opcodes = opcodes
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s (regenerate with -lock)", strings.Join(missing, "\n"))
+	}
+	return nil
+}
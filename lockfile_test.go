@@ -0,0 +1,61 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockfile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "example_amd64.s"+lockSuffix)
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ  XMM0,XMM1,XMM8", lineno: 1, backend: "gas", opcodes: []byte{0xc4, 0xc1, 0x71, 0xd4, 0xc0}},
+		{instruction: " VPALIGNR XMM8, XMM12, XMM12, 0x8", lineno: 2, backend: "gas", opcodes: []byte{0xc4, 0x43, 0x19, 0x0f, 0xc4, 0x08}},
+	}
+	if err := writeLockfile(path, instructions, archAmd64, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	verified := []Instruction{
+		{instruction: " VPALIGNR   XMM8, XMM12, XMM12, 0x8", lineno: 7},
+		{instruction: " VPADDQ  XMM0,XMM1,XMM8", lineno: 9},
+	}
+	if err := fromLockfile(path, verified, archAmd64); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified[0].opcodes, instructions[1].opcodes) || !bytes.Equal(verified[1].opcodes, instructions[0].opcodes) {
+		t.Errorf("unexpected opcodes %v", verified)
+	}
+
+	if err := fromLockfile(path, []Instruction{{instruction: " VPADDQ  XMM0,XMM1,XMM9"}}, archAmd64); err == nil {
+		t.Errorf("expected error for instruction missing from lockfile")
+	}
+	if err := fromLockfile(path, verified, archArm64); err == nil {
+		t.Errorf("expected error for wrong architecture")
+	}
+}
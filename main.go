@@ -0,0 +1,387 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var (
+	check  = flag.Bool("check", false, "report instructions with stale byte sequences instead of rewriting files")
+	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+	lock   = flag.Bool("lock", false, "record the encodings in a lockfile next to every file")
+	verify = flag.Bool("verify", false, "check files against their lockfile without running an assembler (implies -check)")
+
+	partial = flag.Bool("partial", false, "still rewrite the instructions that assembled when others fail")
+
+	backendName = flag.String("backend", envOr("ASM2PLAN9S_BACKEND", backendAuto), "assembler backend to use: "+strings.Join(backendNames(), ", ")+" (or set $ASM2PLAN9S_BACKEND)")
+	asTools     = flag.String("as", os.Getenv("ASM2PLAN9S_AS"), "GNU assembler or cross prefix (ending in '-') to use, as a comma separated list of [arch=]tool entries (or set $ASM2PLAN9S_AS)")
+	archName    = flag.String("arch", "", "target architecture: "+strings.Join(archNames(), ", ")+" (default: detected from the file name, a //go:build line or the host)")
+	march       = flag.String("march", defaultMarch, "architecture level and extensions for arm64 (as passed to GAS, eg. armv8.2-a+sha3)")
+	jobs        = flag.Int("j", runtime.GOMAXPROCS(0), "maximum number of assembler processes run concurrently")
+	noCache     = flag.Bool("nocache", false, "do not use the encoding cache")
+	cacheDir    = flag.String("cachedir", envOr("ASM2PLAN9S_CACHE", defaultCacheDir()), "directory to cache encodings in (or set $ASM2PLAN9S_CACHE)")
+	doClear     = flag.Bool("clearcache", false, "remove all cached encodings and exit")
+	verbose     = flag.Bool("v", false, "verbose: report the architecture and backend used for every file, and lines that nearly are instructions")
+	doExplain   = flag.Bool("explain", false, "report for every comment whether it is taken as an instruction and why")
+	marker      = flag.String("marker", "", "tag marking an instruction comment at any position, eg. "+exampleMarker+" for //"+exampleMarker+" INSTR (default none)")
+
+	compact        = flag.Bool("compact", false, "combine instructions on consecutive lines into a single line")
+	compactMax     = flag.Int("compact-max", 0, "maximum number of bytes per compacted line (0 for no limit)")
+	compactBlank   = flag.Bool("compact-blank", false, "continue compaction across blank and comment lines")
+	compactRegions = flag.Bool("compact-regions", false, "only compact between "+directiveCompact+" and "+directiveEndCompact+" lines")
+)
+
+// envOr returns the value of the environment variable key, or def when it is not set
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// options returns the assembler options for file as set on the command line
+func options(file string, log io.Writer) Options {
+	dir := *cacheDir
+	if *noCache {
+		dir = ""
+	}
+	return Options{
+		Filename: file,
+		Arch:     *archName,
+		Backend:  *backendName,
+		As:       *asTools,
+		Jobs:     *jobs,
+		March:    *march,
+		CacheDir: dir,
+		Verbose:  *verbose,
+		Explain:  *doExplain,
+		Log:      log,
+		Verify:   *verify,
+		Marker:   *marker,
+		Compact: CompactOptions{
+			Enabled:    *compact || *compactRegions,
+			MaxBytes:   *compactMax,
+			CrossBlank: *compactBlank,
+			Regions:    *compactRegions,
+		},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: asm2plan9s [flags] [path ...]\n\n")
+	fmt.Fprintf(os.Stderr, "Without a path, asm2plan9s reads from standard input and writes to standard output.\n")
+	fmt.Fprintf(os.Stderr, "Directories (or dir/...) are searched recursively for *_amd64.s and *_arm64.s files.\n\n")
+	flag.PrintDefaults()
+}
+
+// expandPaths turns the command line arguments into the list of files to
+// process, expanding glob patterns and walking directories. As with the go
+// tool, a path ending in ... is walked like the directory in front of it.
+func expandPaths(args []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "..." || strings.HasSuffix(arg, "/...") {
+			arg = filepath.Clean(strings.TrimSuffix(arg, "..."))
+		}
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matching files", arg)
+			}
+		}
+
+		for _, match := range matches {
+			fi, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				add(match)
+				continue
+			}
+			found, err := walkDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range found {
+				add(file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// walkDir returns all assembly files below dir that are named after
+// an architecture supported by asm2plan9s.
+func walkDir(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if fi.IsDir() {
+			// Skip hidden directories and those ignored by the go tool
+			if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, "_amd64.s") || strings.HasSuffix(name, "_arm64.s") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// lockfilePath returns the lockfile to verify file against or to record its
+// encodings in. Read-only runs (-check, -d) never write a lockfile.
+func lockfilePath(file string) string {
+	if *verify || (*lock && !*check && !*doDiff) {
+		return file + lockSuffix
+	}
+	return ""
+}
+
+// errStale is returned in check mode when a file is not up to date.
+var errStale = errors.New("stale byte sequences")
+
+// processFile assembles a single file and rewrites it in place, or in
+// check or diff mode writes its findings to stdout and stderr instead.
+func processFile(file string, stdout, stderr io.Writer) error {
+	if !*check && !*doDiff && !*verify {
+		fmt.Fprintln(stderr, "Processing file", file)
+	}
+
+	lines, newline, err := readLines(file, nil)
+	if err != nil {
+		return err
+	}
+
+	opts := options(file, stderr)
+	opts.Lockfile = lockfilePath(file)
+	result, err := assembleWith(lines, opts)
+	if errs, ok := err.(AssembleErrors); ok {
+		return reportErrors(file, lines, result, newline, errs, stdout, stderr)
+	} else if err != nil {
+		return err
+	}
+
+	return report(file, lines, result, newline, stdout, stderr)
+}
+
+// reportErrors prints the instructions that failed to assemble, one per
+// line, and with -partial reports the lines that did assemble as usual.
+func reportErrors(file string, lines, result []string, newline bool, errs AssembleErrors, stdout, stderr io.Writer) error {
+	for _, e := range errs {
+		fmt.Fprintln(stderr, e)
+	}
+	if *partial {
+		if err := report(file, lines, result, newline, stdout, stderr); err != nil && err != errStale {
+			return err
+		}
+	}
+	return errs
+}
+
+// report outputs the result of assembling the lines according to the
+// selected mode. Without -check or -d the result is written to the file,
+// or to stdout when reading from standard input. The last line is ended
+// with a newline if the original did.
+func report(file string, lines, result []string, newline bool, stdout, stderr io.Writer) error {
+	if *doDiff {
+		fmt.Fprint(stdout, unifiedDiff(file+".orig", file, lines, result))
+	}
+	if *check || *verify {
+		return reportStale(file, lines, result, stderr)
+	}
+	if *doDiff {
+		return nil
+	}
+	if file == stdinName {
+		return writeLines(result, newline, "", stdout)
+	}
+	return writeLines(result, newline, file, nil)
+}
+
+// reportStale prints every line whose byte sequence differs
+// from the assembled result.
+func reportStale(file string, lines, result []string, stderr io.Writer) error {
+	stale := staleLines(lines, result)
+	for _, s := range stale {
+		fmt.Fprintf(stderr, "%s:%d: have %q, want %q\n", file, s.lineno+1, s.have, s.want)
+	}
+	if len(stale) > 0 {
+		return errStale
+	}
+	return nil
+}
+
+// processFiles processes the files concurrently with process and returns
+// the errors encountered in the same order as the files. Output is buffered
+// per file so that it does not interleave, and is written in order as soon
+// as a file and all files before it are done.
+func processFiles(files []string, process func(file string, stdout, stderr io.Writer) error, stdout, stderr io.Writer) []error {
+	errs := make([]error, len(files))
+	stdouts := make([]bytes.Buffer, len(files))
+	stderrs := make([]bytes.Buffer, len(files))
+	done := make([]chan struct{}, len(files))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	go func() {
+		sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+		for i, file := range files {
+			sem <- struct{}{}
+			go func(i int, file string) {
+				defer close(done[i])
+				defer func() { <-sem }()
+				errs[i] = process(file, &stdouts[i], &stderrs[i])
+			}(i, file)
+		}
+	}()
+
+	for i := range files {
+		<-done[i]
+		stdout.Write(stdouts[i].Bytes())
+		stderr.Write(stderrs[i].Bytes())
+	}
+	return errs
+}
+
+// Name used in reports when reading from standard input
+const stdinName = "<standard input>"
+
+// processStdin assembles standard input and writes the result to standard output.
+func processStdin() error {
+	lines, newline, err := readLines("", os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	if *lock || *verify {
+		return errors.New("lockfiles are not supported when reading from standard input")
+	}
+
+	result, err := assembleWith(lines, options(stdinName, os.Stderr))
+	if errs, ok := err.(AssembleErrors); ok {
+		return reportErrors(stdinName, lines, result, newline, errs, os.Stdout, os.Stderr)
+	} else if err != nil {
+		return err
+	}
+
+	return report(stdinName, lines, result, newline, os.Stdout, os.Stderr)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	setMaxProcs(*jobs)
+
+	if !validBackend(*backendName) {
+		fmt.Fprintf(os.Stderr, "unknown backend %q (use one of %s)\n", *backendName, strings.Join(backendNames(), ", "))
+		os.Exit(2)
+	}
+	if *archName != "" && lookupArch(*archName) == nil {
+		fmt.Fprintf(os.Stderr, "unsupported architecture %q (use one of %s)\n", *archName, strings.Join(archNames(), ", "))
+		os.Exit(2)
+	}
+
+	if *doClear {
+		if err := clearCache(*cacheDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() == 0 {
+		if err := processStdin(); err == errStale {
+			os.Exit(1)
+		} else if errs, ok := err.(AssembleErrors); ok {
+			fmt.Fprintln(os.Stderr, summary(errs.instructions(), 1))
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	files, err := expandPaths(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	failed, rejected, rejectedFiles := false, 0, 0
+	for i, err := range processFiles(files, processFile, os.Stdout, os.Stderr) {
+		if err == errStale {
+			failed = true
+		} else if errs, ok := err.(AssembleErrors); ok {
+			rejected += errs.instructions()
+			rejectedFiles++
+			failed = true
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", files[i], err)
+			failed = true
+		}
+	}
+	if rejected > 0 {
+		fmt.Fprintln(os.Stderr, summary(rejected, rejectedFiles))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// summary describes the number of instructions that failed to assemble
+func summary(instructions, files int) string {
+	plural := func(n int, s string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, s)
+		}
+		return fmt.Sprintf("%d %ss", n, s)
+	}
+	return fmt.Sprintf("%s in %s failed to assemble", plural(instructions, "instruction"), plural(files, "file"))
+}
@@ -0,0 +1,224 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportDiff(t *testing.T) {
+
+	in := "TEXT ·foo(SB), 7, $0\n" +
+		"    MOVQ AX, BX\n" +
+		"    LONG $0x003377bb; BYTE $0xff // VPADDQ  XMM0,XMM1,XMM8\n" +
+		"    RET\n"
+	out := `--- foo_amd64.s.orig
++++ foo_amd64.s
+@@ -1,4 +1,4 @@
+ TEXT ·foo(SB), 7, $0
+     MOVQ AX, BX
+-    LONG $0x003377bb; BYTE $0xff // VPADDQ  XMM0,XMM1,XMM8
++    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8
+     RET
+`
+
+	lines, newline, err := readLines("", strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := append([]string{}, lines...)
+	result[2] = "    LONG $0xd471c1c4; BYTE $0xc0 // VPADDQ  XMM0,XMM1,XMM8"
+
+	defer func(d bool) { *doDiff = d }(*doDiff)
+	*doDiff = true
+
+	var stdout, stderr bytes.Buffer
+	if err := report("foo_amd64.s", lines, result, newline, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != out {
+		t.Errorf("expected %s\ngot                     %s", out, stdout.String())
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		"a_amd64.s", "b_arm64.s", "c.go", "d.s",
+		"sub/e_amd64.s", "sub/notes.txt",
+		".git/f_amd64.s", "_skip/g_arm64.s",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		return paths
+	}
+
+	testCases := []struct {
+		args  []string
+		files []string
+	}{
+		{join(""), join("a_amd64.s", "b_arm64.s", "sub/e_amd64.s")},
+		{[]string{dir + "/..."}, join("a_amd64.s", "b_arm64.s", "sub/e_amd64.s")},
+		{[]string{dir + "/sub/..."}, join("sub/e_amd64.s")},
+		{join("_skip"), join("_skip/g_arm64.s")},
+		{join("sub", ""), join("sub/e_amd64.s", "a_amd64.s", "b_arm64.s")},
+		{join("c.go", "c.go"), join("c.go")},
+		{join("*.s"), join("a_amd64.s", "b_arm64.s", "d.s")},
+		{join("*"), join(".git/f_amd64.s", "_skip/g_arm64.s", "a_amd64.s", "b_arm64.s", "c.go", "d.s", "sub/e_amd64.s")},
+	}
+
+	for _, tc := range testCases {
+		files, err := expandPaths(tc.args)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, tc.files) {
+			t.Errorf("expected %v\ngot                     %v", tc.files, files)
+		}
+	}
+
+	for _, args := range [][]string{join("missing"), join("*.asm"), {dir + "/missing/..."}} {
+		if _, err := expandPaths(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestProcessFileProgress(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "foo_amd64.s")
+	if err := ioutil.WriteFile(file, []byte("TEXT ·foo(SB), 7, $0\n    RET\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := processFile(file, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Processing file " + file + "\n"; stderr.String() != expected {
+		t.Errorf("expected %q\ngot                     %q", expected, stderr.String())
+	}
+}
+
+func TestLockfilePath(t *testing.T) {
+
+	defer func(l, c, d, v bool) { *lock, *check, *doDiff, *verify = l, c, d, v }(*lock, *check, *doDiff, *verify)
+
+	testCases := []struct {
+		lock, check, diff, verify bool
+		lockfile                  string
+	}{
+		{false, false, false, false, ""},
+		{true, false, false, false, "foo_arm64.s.lock"},
+		{true, true, false, false, ""},
+		{true, false, true, false, ""},
+		{false, true, false, true, "foo_arm64.s.lock"},
+		{true, true, false, true, "foo_arm64.s.lock"},
+	}
+
+	for _, tc := range testCases {
+		*lock, *check, *doDiff, *verify = tc.lock, tc.check, tc.diff, tc.verify
+		if lockfile := lockfilePath("foo_arm64.s"); lockfile != tc.lockfile {
+			t.Errorf("expected %q\ngot                     %q", tc.lockfile, lockfile)
+		}
+	}
+}
+
+// chanWriter sends everything written to it on a channel
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestProcessFilesProgress(t *testing.T) {
+
+	files := []string{"a_amd64.s", "b_amd64.s", "c_amd64.s"}
+	release := make(map[string]chan struct{})
+	for _, file := range files {
+		release[file] = make(chan struct{})
+	}
+	process := func(file string, stdout, stderr io.Writer) error {
+		fmt.Fprintln(stderr, "Processing file", file)
+		<-release[file]
+		return nil
+	}
+
+	out := make(chanWriter, 2*len(files))
+	result := make(chan []error)
+	go func() { result <- processFiles(files, process, ioutil.Discard, out) }()
+
+	next := func() string {
+		select {
+		case s := <-out:
+			return s
+		case <-time.After(5 * time.Second):
+			return "nothing"
+		}
+	}
+
+	// Output of a file that is done waits for the files before it, and
+	// is written before the files after it are done
+	close(release["b_amd64.s"])
+	close(release["a_amd64.s"])
+	for _, file := range files[:2] {
+		if s := next(); s != "Processing file "+file+"\n" {
+			t.Fatalf("expected progress of %s\ngot                     %q", file, s)
+		}
+	}
+	close(release["c_amd64.s"])
+	if s := next(); s != "Processing file c_amd64.s\n" {
+		t.Errorf("expected progress of c_amd64.s\ngot                     %q", s)
+	}
+	if errs := <-result; len(errs) != len(files) {
+		t.Errorf("expected %d results, got %v", len(files), errs)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Names of (cross) GNU assemblers searched for per architecture,
+// after the native `as` when running on that architecture
+var gasCandidates = map[string][]string{
+	"amd64": {"x86_64-linux-gnu-as", "x86_64-pc-linux-gnu-as", "x86_64-unknown-linux-gnu-as", "x86_64-elf-as", "x86_64-w64-mingw32-as"},
+	"arm64": {"aarch64-linux-gnu-as", "aarch64-unknown-linux-gnu-as", "aarch64-none-linux-gnu-as", "aarch64-elf-as", "aarch64-none-elf-as", "aarch64-linux-android-as"},
+}
+
+// Suggested packages to install when no assembler can be found
+var gasPackages = map[string]string{
+	"amd64": "binutils-x86-64-linux-gnu",
+	"arm64": "binutils-aarch64-linux-gnu",
+}
+
+// Architectures of the CPU names starting a target triple (x86_64-linux-gnu)
+var tripleArchs = map[string]string{
+	"x86_64": "amd64", "amd64": "amd64",
+	"aarch64": "arm64", "arm64": "arm64",
+}
+
+// tripleArch returns the architecture of the target triple in front of the
+// name of an assembler or cross prefix, or "" when it has none
+func tripleArch(tool string) string {
+	name := filepath.Base(tool)
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return ""
+	}
+	return tripleArchs[name[:i]]
+}
+
+var (
+	gasMu    sync.Mutex
+	gasFound = make(map[string]string)
+)
+
+// gasCommand returns the GNU assembler to run for the architecture.
+//
+// The spec is a comma separated list of [arch=]tool entries where tool is
+// either the path to an assembler or a cross prefix ending in a dash (such as
+// "aarch64-linux-gnu-"). An entry for the architecture takes precedence over
+// one without. An entry without arch= that is named after a target triple
+// (such as "x86_64-linux-gnu-") only applies to the architecture of that
+// triple, and comes before an entry that applies to any architecture. A
+// cross prefix needs either arch= or a triple. Without a matching entry, the
+// native `as` is used on a host of the same architecture, followed by the
+// first known cross assembler in $PATH.
+func gasCommand(arch, spec string) (string, error) {
+	var generic, named, specific, unknown string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if i := strings.Index(entry, "="); i >= 0 {
+			if entry[:i] == arch {
+				specific = entry[i+1:]
+			}
+			continue
+		}
+		switch target := tripleArch(entry); {
+		case target == arch:
+			named = entry
+		case target == "" && strings.HasSuffix(entry, "-"):
+			unknown = entry
+		case target == "":
+			generic = entry
+		}
+	}
+	if specific == "" && named == "" && generic == "" && unknown != "" {
+		return "", fmt.Errorf("cannot tell the architecture of cross prefix %q, use %s=%s", unknown, arch, unknown)
+	}
+	for _, tool := range []string{specific, named, generic} {
+		if tool == "" {
+			continue
+		}
+		if strings.HasSuffix(tool, "-") {
+			return tool + "as", nil
+		}
+		return tool, nil
+	}
+
+	gasMu.Lock()
+	defer gasMu.Unlock()
+
+	if tool, ok := gasFound[arch]; ok {
+		return tool, nil
+	}
+
+	candidates := gasCandidates[arch]
+	if arch == runtime.GOARCH {
+		candidates = append([]string{"as"}, candidates...)
+	}
+	for _, c := range candidates {
+		if path, err := exec.LookPath(c); err == nil {
+			gasFound[arch] = path
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no GNU assembler for %s found (tried %s), install %s or use -as to set one", arch, strings.Join(candidates, ", "), gasPackages[arch])
+}
@@ -0,0 +1,101 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGasCommandSpec(t *testing.T) {
+
+	testCases := []struct {
+		arch string
+		spec string
+		out  string
+	}{
+		{"arm64", "aarch64-linux-gnu-", "aarch64-linux-gnu-as"},
+		{"arm64", "/opt/cross/bin/aarch64-elf-as", "/opt/cross/bin/aarch64-elf-as"},
+		{"arm64", "amd64=/usr/bin/as, arm64=aarch64-none-elf-", "aarch64-none-elf-as"},
+		{"amd64", "amd64=/usr/bin/as, arm64=aarch64-none-elf-", "/usr/bin/as"},
+		{"amd64", "/usr/local/bin/gas,arm64=aarch64-none-elf-", "/usr/local/bin/gas"},
+		{"arm64", "/usr/local/bin/gas,arm64=aarch64-none-elf-", "aarch64-none-elf-as"},
+		{"amd64", "x86_64-w64-mingw32-, aarch64-linux-gnu-", "x86_64-w64-mingw32-as"},
+		{"arm64", "x86_64-w64-mingw32-, aarch64-linux-gnu-", "aarch64-linux-gnu-as"},
+		{"arm64", "/opt/cross/bin/aarch64-elf-as, /usr/bin/as", "/opt/cross/bin/aarch64-elf-as"},
+		{"arm64", "mycross-, arm64=aarch64-none-elf-", "aarch64-none-elf-as"},
+	}
+
+	for i, tc := range testCases {
+		result, err := gasCommand(tc.arch, tc.spec)
+		if err != nil || result != tc.out {
+			t.Errorf("test %d: expected %s\ngot                     %s (%v)", i, tc.out, result, err)
+		}
+	}
+
+	// A cross prefix that is not named after a target triple
+	if _, err := gasCommand("arm64", "mycross-"); err == nil {
+		t.Errorf("expected error for cross prefix without architecture")
+	}
+}
+
+func TestGasCommandMixedTree(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "asm2plan9s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"sha256_amd64.s", "sha256_arm64.s"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("TEXT ·f(SB), 7, $0\n    RET\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The assembler found for amd64 when there is no entry for it
+	gasMu.Lock()
+	defer func(found map[string]string) {
+		gasMu.Lock()
+		gasFound = found
+		gasMu.Unlock()
+	}(gasFound)
+	gasFound = map[string]string{"amd64": "/usr/bin/as"}
+	gasMu.Unlock()
+
+	files, err := expandPaths([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"amd64": "/usr/bin/as", "arm64": "aarch64-linux-gnu-as"}
+	for _, file := range files {
+		arch, err := detectArch("", file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The cross prefix for arm64 is not used for amd64 files
+		app, err := gasCommand(arch.name, "aarch64-linux-gnu-")
+		if err != nil || app != want[arch.name] {
+			t.Errorf("%s: expected %s\ngot                     %s (%v)", filepath.Base(file), want[arch.name], app, err)
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("expected %d files, got %v", len(want), files)
+	}
+}
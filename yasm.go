@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -38,33 +41,147 @@ import (
 // 00000000 <.text>:
 // 0:   c5 ed ef e3             vpxor  ymm4,ymm2,ymm3
 
-func yasm(instructions []Instruction) error {
+func yasm(instructions []Instruction, opts *Options) error {
+	err := yasmBatch(instructions, opts.Filename)
+	if _, ok := err.(errBatch); ok {
+		// Could not split the output, assemble one by one instead
+		return yasmEach(instructions, opts.Jobs, opts.Filename)
+	}
+	return err
+}
+
+// yasmVersion returns the version of yasm
+func yasmVersion(opts *Options) (string, error) {
+	if _, err := exec.LookPath("yasm"); err != nil {
+		return "", errors.New("exec error: YASM not installed?")
+	}
+	return toolVersion("yasm")
+}
+
+// Label placed in front of every instruction in a batch
+const yasmLabel = "asm2plan9s_%d"
+
+// yasmBatch assembles all instructions in a single run of yasm. A label is
+// placed in front of every instruction, and the distances between these
+// labels (ie. the lengths of the instructions) are appended as a table of
+// dwords to the flat binary output:
+//
+//	[bits 64]
+//	asm2plan9s_0:
+//	VPADDQ  XMM0,XMM1,XMM8
+//	asm2plan9s_1:
+//	VPXOR   YMM4, YMM2, YMM3
+//	asm2plan9s_2:
+//	dd asm2plan9s_1-asm2plan9s_0, asm2plan9s_2-asm2plan9s_1
+func yasmBatch(instructions []Instruction, filename string) error {
+
+	tmpfile, err := ioutil.TempFile("", "asm2plan9s")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpfile.Write(yasmSource(instructions)); err != nil {
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+
+	asmFile := tmpfile.Name() + ".asm"
+	objFile := tmpfile.Name() + ".obj"
+	os.Rename(tmpfile.Name(), asmFile)
+
+	defer os.Remove(asmFile) // clean up
+	defer os.Remove(objFile) // clean up
+
+	cmd := exec.Command("yasm", "-o", objFile, asmFile)
+	cmb, err := runAssembler(cmd)
+	if err != nil {
+		if len(string(cmb)) == 0 { // command invocation failed
+			return errors.New("exec error: YASM not installed?")
+		}
+		// Instruction i is on line 2i+3 (after [bits 64] and its label)
+		return yasmDiagnostics.errors(string(cmb), asmFile, labelledIndex(3, len(instructions)), instructions, filename)
+	}
+
+	out, err := ioutil.ReadFile(objFile)
+	if err != nil {
+		return err
+	}
+
+	opcodes, err := splitYasmOutput(out, len(instructions))
+	if err != nil {
+		return err
+	}
+	for i := range instructions {
+		instructions[i].opcodes = opcodes[i]
+	}
+	return nil
+}
+
+// yasmSource returns the source of a batch of instructions, see yasmBatch
+func yasmSource(instructions []Instruction) []byte {
+	var src bytes.Buffer
+	src.WriteString("[bits 64]\n")
+	lengths := make([]string, len(instructions))
 	for i, ins := range instructions {
-		assembled, opcodes, err := yasmSingle(ins.instruction, ins.lineno, ins.commentPos, ins.inDefine)
+		fmt.Fprintf(&src, yasmLabel+":\n%s\n", i, ins.instruction)
+		lengths[i] = fmt.Sprintf(yasmLabel+"-"+yasmLabel, i+1, i)
+	}
+	fmt.Fprintf(&src, yasmLabel+":\n", len(instructions))
+	fmt.Fprintf(&src, "dd %s\n", strings.Join(lengths, ", "))
+	return src.Bytes()
+}
+
+// splitYasmOutput splits the flat binary output of a batch of n
+// instructions at the lengths in the table that follows the code
+func splitYasmOutput(out []byte, n int) ([][]byte, error) {
+	table := len(out) - 4*n
+	if table < 0 {
+		return nil, errBatch("YASM output too short")
+	}
+	opcodes := make([][]byte, n)
+	offset := 0
+	for i := range opcodes {
+		length := int(binary.LittleEndian.Uint32(out[table+4*i:]))
+		if offset+length > table {
+			return nil, errBatch("YASM output does not match instruction lengths")
+		}
+		opcodes[i] = make([]byte, length)
+		copy(opcodes[i], out[offset:offset+length])
+		offset += length
+	}
+	if offset != table {
+		return nil, errBatch("YASM output does not match instruction lengths")
+	}
+	return opcodes, nil
+}
+
+// yasmEach assembles the instructions one at a time
+func yasmEach(instructions []Instruction, jobs int, filename string) error {
+	return forEach(instructions, jobs, func(ctx context.Context, ins *Instruction) error {
+		opcodes, err := yasmSingle(ctx, ins, filename)
 		if err != nil {
 			return err
 		}
-		instructions[i].assembled = assembled
-		instructions[i].opcodes = make([]byte, len(opcodes))
-		copy(instructions[i].opcodes[:], opcodes)
-	}
-	return nil
+		ins.opcodes = make([]byte, len(opcodes))
+		copy(ins.opcodes[:], opcodes)
+		return nil
+	})
 }
 
-func yasmSingle(instr string, lineno, commentPos int, inDefine bool) (string, []byte, error) {
+func yasmSingle(ctx context.Context, ins *Instruction, filename string) ([]byte, error) {
 
-	instrFields := strings.Split(instr, "/*")
-	content := []byte("[bits 64]\n" + instrFields[0])
+	content := []byte("[bits 64]\n" + ins.instruction)
 	tmpfile, err := ioutil.TempFile("", "asm2plan9s")
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	if _, err := tmpfile.Write(content); err != nil {
-		return "", nil, err
+		return nil, err
 	}
 	if err := tmpfile.Close(); err != nil {
-		return "", nil, err
+		return nil, err
 	}
 
 	asmFile := tmpfile.Name() + ".asm"
@@ -80,28 +197,18 @@ func yasmSingle(instr string, lineno, commentPos int, inDefine bool) (string, []
 	arg1 := objFile
 	arg2 := asmFile
 
-	cmd := exec.Command(app, arg0, arg1, arg2)
-	cmb, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, app, arg0, arg1, arg2)
+	cmb, err := runAssembler(cmd)
 	if err != nil {
 		if len(string(cmb)) == 0 { // command invocation failed
-			return "", nil, errors.New("exec error: YASM not installed?")
+			return nil, errors.New("exec error: YASM not installed?")
 		}
-		yasmErrs := strings.Split(string(cmb)[len(asmFile)+1:], ":")
-		yasmErr := strings.Join(yasmErrs[1:], ":")
-		return "", nil, errors.New(fmt.Sprintf("YASM error (line %d for '%s'):", lineno+1, strings.TrimSpace(instr)) + yasmErr)
-	}
-
-	return toPlan9sYasm(objFile, instr, commentPos, inDefine)
-}
-
-func toPlan9sYasm(objFile, instr string, commentPos int, inDefine bool) (string, []byte, error) {
-	opcodes, err := ioutil.ReadFile(objFile)
-	if err != nil {
-		return "", nil, err
+		return nil, yasmDiagnostics.errors(string(cmb), asmFile, func(line int) int {
+			return 0
+		}, []Instruction{*ins}, filename)
 	}
 
-	s, err := toPlan9s(opcodes, instr, commentPos, inDefine)
-	return s, opcodes, err
+	return ioutil.ReadFile(objFile)
 }
 
 func toPlan9s(opcodes []byte, instr string, commentPos int, inDefine bool) (string, error) {
@@ -149,11 +256,16 @@ func toPlan9s(opcodes []byte, instr string, commentPos int, inDefine bool) (stri
 		opcodes = opcodes[1:]
 	}
 
+	return appendInstruction(sline, instr, commentPos, inDefine), nil
+}
+
+// appendInstruction pads the opcode sequence up to the starting position of
+// the comment (preserving a #define continuation) and appends the comment
+// holding the instruction as is
+func appendInstruction(sline, instr string, commentPos int, inDefine bool) string {
 	if inDefine {
-		if commentPos > commentPos-2-len(sline) {
-			if commentPos-2-len(sline) > 0 {
-				sline += strings.Repeat(" ", commentPos-2-len(sline))
-			}
+		if commentPos-2 > len(sline) {
+			sline += strings.Repeat(" ", commentPos-2-len(sline))
 		} else {
 			sline += " "
 		}
@@ -168,9 +280,8 @@ func toPlan9s(opcodes []byte, instr string, commentPos int, inDefine bool) (stri
 		}
 	}
 
-	if instr != "" {
-		sline += "//" + instr
+	if instr == "" {
+		return strings.TrimRightFunc(sline, unicode.IsSpace)
 	}
-
-	return strings.TrimRightFunc(sline, unicode.IsSpace), nil
+	return sline + "//" + instr
 }
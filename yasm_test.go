@@ -0,0 +1,119 @@
+/*
+ * Minio Cloud Storage, (C) 2016-2017 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestYasmSource(t *testing.T) {
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ  XMM0,XMM1,XMM8"},
+		{instruction: " VPXOR   YMM4, YMM2, YMM3"},
+	}
+	out := `[bits 64]
+asm2plan9s_0:
+ VPADDQ  XMM0,XMM1,XMM8
+asm2plan9s_1:
+ VPXOR   YMM4, YMM2, YMM3
+asm2plan9s_2:
+dd asm2plan9s_1-asm2plan9s_0, asm2plan9s_2-asm2plan9s_1
+`
+	if src := string(yasmSource(instructions)); src != out {
+		t.Errorf("expected %s\ngot                     %s", out, src)
+	}
+}
+
+func TestSplitYasmOutput(t *testing.T) {
+
+	testCases := []struct {
+		out     []byte
+		n       int
+		opcodes [][]byte
+	}{
+		{[]byte{0xc5, 0xed, 0xef, 0xe3, 0x90, 4, 0, 0, 0, 1, 0, 0, 0}, 2, [][]byte{{0xc5, 0xed, 0xef, 0xe3}, {0x90}}},
+		// Instructions without code (eg. a label)
+		{[]byte{0x90, 0, 0, 0, 0, 1, 0, 0, 0}, 2, [][]byte{{}, {0x90}}},
+		{[]byte{}, 0, [][]byte{}},
+		// Table missing
+		{[]byte{0x90, 1, 0, 0}, 1, nil},
+		// Lengths beyond the code
+		{[]byte{0x90, 2, 0, 0, 0}, 1, nil},
+		// Code not covered by the lengths
+		{[]byte{0x90, 0x90, 1, 0, 0, 0}, 1, nil},
+	}
+
+	for i, tc := range testCases {
+		opcodes, err := splitYasmOutput(tc.out, tc.n)
+		if tc.opcodes == nil {
+			// Fall back to assembling one instruction at a time
+			if _, ok := err.(errBatch); !ok {
+				t.Errorf("test %d: expected batch error, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if len(opcodes) != len(tc.opcodes) {
+			t.Errorf("test %d: expected %x\ngot                     %x", i, tc.opcodes, opcodes)
+			continue
+		}
+		for j := range opcodes {
+			if !bytes.Equal(opcodes[j], tc.opcodes[j]) {
+				t.Errorf("test %d: expected %x\ngot                     %x", i, tc.opcodes[j], opcodes[j])
+			}
+		}
+	}
+}
+
+func TestYasmBatch(t *testing.T) {
+
+	if _, err := exec.LookPath("yasm"); err != nil {
+		t.Skip("YASM not installed")
+	}
+
+	instructions := []Instruction{
+		{instruction: " VPADDQ  XMM0,XMM1,XMM8"},
+		{instruction: " NOP"},
+		{instruction: " VPXOR   YMM4, YMM2, YMM3"},
+	}
+	if err := yasmBatch(instructions, "test_amd64.s"); err != nil {
+		t.Fatal(err)
+	}
+	out := [][]byte{{0xc4, 0xc1, 0x71, 0xd4, 0xc0}, {0x90}, {0xc5, 0xed, 0xef, 0xe3}}
+	for i, ins := range instructions {
+		if !bytes.Equal(ins.opcodes, out[i]) {
+			t.Errorf("expected %x\ngot                     %x", out[i], ins.opcodes)
+		}
+	}
+
+	// An error yasm reports outside of the instructions fails the batch,
+	// the instructions are then assembled one at a time
+	broken := []Instruction{{instruction: " NOP\n FOO RAX", lineno: 4}}
+	if _, ok := yasmBatch(broken, "test_amd64.s").(errBatch); !ok {
+		t.Errorf("expected batch error")
+	}
+	errs, ok := yasm(broken, &Options{Filename: "test_amd64.s"}).(AssembleErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 5 {
+		t.Errorf("expected error for line 5, got %v", errs)
+	}
+}